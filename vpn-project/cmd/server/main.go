@@ -1,15 +1,22 @@
 package main
 
 import (
+	"os"
+
 	"vpn-project/internal/vpnserver"
 )
 
 func main() {
-	logger := vpnserver.NewLogger()
+	logger, closeLog := vpnserver.NewLogger()
 	cfg := vpnserver.LoadConfigFromEnv()
 	app := vpnserver.NewApp(cfg, logger)
 
-	if err := app.Run(); err != nil {
-		logger.Fatalf("%v", err)
+	err := app.Run()
+	if err != nil {
+		logger.Printf("%v", err)
+	}
+	closeLog()
+	if err != nil {
+		os.Exit(1)
 	}
 }
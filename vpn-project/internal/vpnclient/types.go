@@ -6,6 +6,8 @@ type ClientConfigResponse struct {
 	ID       string `json:"id"`
 	Name     string `json:"name"`
 	Config   string `json:"config"`
+	ShareURI string `json:"share_uri,omitempty"`
+	// Deprecated: use ShareURI; the server still sends both.
 	VLESSURI string `json:"vless_uri,omitempty"`
 	QRBase64 string `json:"qr_base64"`
 }
@@ -0,0 +1,308 @@
+package vpnserver
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+const acmeRetryDelay = 10 * time.Minute
+
+// checkACMEBind refuses a challenge listener on a TCP port sing-box already
+// holds: sing-box runs while certificates are renewed, so the challenge
+// listener could never bind. This is what the tls-alpn-01 default ":443"
+// hits with the default VLESS_LISTEN_PORT.
+func (c Config) checkACMEBind() error {
+	if c.TLSMode != TLSModeACME {
+		return nil
+	}
+	_, rawPort, err := net.SplitHostPort(c.ACMEBind)
+	if err != nil {
+		return fmt.Errorf("invalid VLESS_ACME_BIND %q: %w", c.ACMEBind, err)
+	}
+	port, err := strconv.Atoi(rawPort)
+	if err != nil {
+		return fmt.Errorf("invalid VLESS_ACME_BIND %q: %w", c.ACMEBind, err)
+	}
+	for _, in := range c.inboundConfigs() {
+		// Hysteria2 listens on UDP and does not collide with the TCP challenge.
+		if in.Protocol == ProtocolHysteria2 || in.Port != port {
+			continue
+		}
+		return fmt.Errorf("VLESS_ACME_BIND %s uses TCP port %d of sing-box listener %s; bind the %s challenge to a free port and forward the public port to it, or use VLESS_ACME_CHALLENGE=http-01 on :80", c.ACMEBind, port, in.Tag, c.ACMEChallenge)
+	}
+	return nil
+}
+
+// renewACMECertificate obtains a new certificate when the current one is a
+// placeholder, does not cover TLSServerName or expires within CertRenewBefore.
+// The ACME exchange runs without holding the manager lock.
+func (m *Manager) renewACMECertificate(ctx context.Context) (bool, error) {
+	domain := strings.TrimSpace(m.cfg.TLSServerName)
+	if domain == "" {
+		return false, errors.New("acme mode requires VLESS_TLS_SERVER_NAME")
+	}
+	if !acmeRenewalDue(m.cfg.TLSCertPath, domain, time.Now(), m.cfg.CertRenewBefore) {
+		return false, nil
+	}
+	return m.issueACMECertificate(ctx)
+}
+
+func (m *Manager) issueACMECertificate(ctx context.Context) (bool, error) {
+	domain := strings.TrimSpace(m.cfg.TLSServerName)
+	if domain == "" {
+		return false, errors.New("acme mode requires VLESS_TLS_SERVER_NAME")
+	}
+
+	certPEM, keyPEM, err := m.obtainACMECertificate(ctx, domain)
+	if err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := writeSecretFile(m.cfg.TLSKeyPath, keyPEM); err != nil {
+		return false, fmt.Errorf("write acme key: %w", err)
+	}
+	if err := writeSecretFile(m.cfg.TLSCertPath, certPEM); err != nil {
+		return false, fmt.Errorf("write acme certificate: %w", err)
+	}
+	m.logger.Printf("obtained ACME certificate for %s at %s", domain, m.cfg.TLSCertPath)
+
+	if err := m.reloadCertificateLocked(); err != nil {
+		return true, fmt.Errorf("reload sing-box after certificate renewal: %w", err)
+	}
+	return true, nil
+}
+
+func (m *Manager) obtainACMECertificate(ctx context.Context, domain string) ([]byte, []byte, error) {
+	accountKey, err := m.loadACMEAccountKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client := &acme.Client{
+		Key:          accountKey,
+		DirectoryURL: m.cfg.ACMEDirectoryURL,
+		UserAgent:    "vpn-manager",
+	}
+	account := &acme.Account{}
+	if m.cfg.ACMEEmail != "" {
+		account.Contact = []string{"mailto:" + m.cfg.ACMEEmail}
+	}
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, nil, fmt.Errorf("acme register: %w", err)
+	}
+
+	ids := acme.DomainIDs(domain)
+	ip := net.ParseIP(domain)
+	if ip != nil {
+		ids = acme.IPIDs(domain)
+	}
+	order, err := client.AuthorizeOrder(ctx, ids)
+	if err != nil {
+		return nil, nil, fmt.Errorf("acme new order: %w", err)
+	}
+	for _, authzURL := range order.AuthzURLs {
+		if err := m.completeACMEAuthorization(ctx, client, authzURL); err != nil {
+			return nil, nil, err
+		}
+	}
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, nil, fmt.Errorf("acme wait order: %w", err)
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	csrTemplate := &x509.CertificateRequest{Subject: pkix.Name{CommonName: domain}}
+	if ip != nil {
+		csrTemplate.IPAddresses = []net.IP{ip}
+	} else {
+		csrTemplate.DNSNames = []string{domain}
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, csrTemplate, certKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create csr: %w", err)
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, nil, fmt.Errorf("acme finalize order: %w", err)
+	}
+
+	var certPEM bytes.Buffer
+	for _, der := range chain {
+		if err := pem.Encode(&certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+			return nil, nil, err
+		}
+	}
+	keyDER, err := x509.MarshalECPrivateKey(certKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM.Bytes(), keyPEM, nil
+}
+
+func (m *Manager) completeACMEAuthorization(ctx context.Context, client *acme.Client, authzURL string) error {
+	authz, err := client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return fmt.Errorf("acme get authorization: %w", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == m.cfg.ACMEChallenge {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("acme server offers no %s challenge for %s", m.cfg.ACMEChallenge, authz.Identifier.Value)
+	}
+
+	stop, err := m.serveACMEChallenge(client, challenge, authz.Identifier.Value)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	if _, err := client.Accept(ctx, challenge); err != nil {
+		return fmt.Errorf("acme accept %s challenge: %w", challenge.Type, err)
+	}
+	if _, err := client.WaitAuthorization(ctx, authzURL); err != nil {
+		return fmt.Errorf("acme authorization for %s: %w", authz.Identifier.Value, err)
+	}
+	return nil
+}
+
+func (m *Manager) serveACMEChallenge(client *acme.Client, challenge *acme.Challenge, identifier string) (func(), error) {
+	switch challenge.Type {
+	case ACMEChallengeHTTP01:
+		response, err := client.HTTP01ChallengeResponse(challenge.Token)
+		if err != nil {
+			return nil, err
+		}
+		path := client.HTTP01ChallengePath(challenge.Token)
+		ln, err := net.Listen("tcp", m.cfg.ACMEBind)
+		if err != nil {
+			return nil, fmt.Errorf("listen for http-01 challenge on %s: %w", m.cfg.ACMEBind, err)
+		}
+		srv := &http.Server{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != path {
+					http.NotFound(w, r)
+					return
+				}
+				_, _ = w.Write([]byte(response))
+			}),
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		go func() { _ = srv.Serve(ln) }()
+		return func() { _ = srv.Close() }, nil
+
+	case ACMEChallengeTLSALPN01:
+		cert, err := client.TLSALPN01ChallengeCert(challenge.Token, identifier)
+		if err != nil {
+			return nil, err
+		}
+		ln, err := tls.Listen("tcp", m.cfg.ACMEBind, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{acme.ALPNProto},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("listen for tls-alpn-01 challenge on %s: %w", m.cfg.ACMEBind, err)
+		}
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+					_ = conn.(*tls.Conn).Handshake()
+					_ = conn.Close()
+				}()
+			}
+		}()
+		return func() { _ = ln.Close() }, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported acme challenge %q", challenge.Type)
+	}
+}
+
+func (m *Manager) loadACMEAccountKey() (crypto.Signer, error) {
+	path := filepath.Join(m.cfg.StateDir, "acme", "account.key")
+	raw, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(raw)
+		if block == nil {
+			return nil, fmt.Errorf("parse acme account key %s: no PEM block", path)
+		}
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse acme account key: %w", err)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read acme account key: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate acme account key: %w", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create acme dir: %w", err)
+	}
+	if err := writeSecretFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+		return nil, fmt.Errorf("write acme account key: %w", err)
+	}
+	m.logger.Printf("generated ACME account key at %s", path)
+	return key, nil
+}
+
+func acmeRenewalDue(certPath, domain string, now time.Time, renewBefore time.Duration) bool {
+	cert, err := loadCertificate(certPath)
+	if err != nil {
+		return true
+	}
+	if isSelfSigned(cert) {
+		return true
+	}
+	if cert.VerifyHostname(domain) != nil {
+		return true
+	}
+	return !now.Add(renewBefore).Before(cert.NotAfter)
+}
@@ -0,0 +1,267 @@
+package vpnserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeACME is a minimal RFC 8555 directory in the spirit of Pebble: it does
+// not verify JWS signatures but does fetch the http-01 response before
+// marking the authorization valid.
+type fakeACME struct {
+	t          *testing.T
+	srv        *httptest.Server
+	caKey      *ecdsa.PrivateKey
+	caCert     *x509.Certificate
+	domain     string
+	validation string
+
+	mu         sync.Mutex
+	authzValid bool
+	leafPEM    []byte
+}
+
+func newFakeACME(t *testing.T, domain, validationAddr string) *fakeACME {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake acme root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(der)
+
+	f := &fakeACME{t: t, caKey: caKey, caCert: caCert, domain: domain, validation: validationAddr}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeACME) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+	base := f.srv.URL
+	if r.URL.Path == "/dir" {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"newNonce":   base + "/nonce",
+			"newAccount": base + "/account",
+			"newOrder":   base + "/order",
+			"revokeCert": base + "/revoke",
+			"keyChange":  base + "/key-change",
+		})
+		return
+	}
+	if r.URL.Path == "/nonce" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	payload := f.jwsPayload(r)
+	order := func(status string) map[string]any {
+		o := map[string]any{
+			"status":         status,
+			"identifiers":    []map[string]string{{"type": "dns", "value": f.domain}},
+			"authorizations": []string{base + "/authz"},
+			"finalize":       base + "/finalize",
+		}
+		if status == "valid" {
+			o["certificate"] = base + "/cert"
+		}
+		return o
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case "/account":
+		w.Header().Set("Location", base+"/account/1")
+		writeJSON(w, http.StatusCreated, map[string]string{"status": "valid"})
+	case "/order":
+		w.Header().Set("Location", base+"/order/1")
+		writeJSON(w, http.StatusCreated, order("pending"))
+	case "/order/1":
+		w.Header().Set("Location", base+"/order/1")
+		status := "pending"
+		if f.authzValid {
+			status = "ready"
+		}
+		if f.leafPEM != nil {
+			status = "valid"
+		}
+		writeJSON(w, http.StatusOK, order(status))
+	case "/authz":
+		status := "pending"
+		if f.authzValid {
+			status = "valid"
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"status":     status,
+			"identifier": map[string]string{"type": "dns", "value": f.domain},
+			"challenges": []map[string]string{{"type": "http-01", "url": base + "/chal", "token": "tok", "status": status}},
+		})
+	case "/chal":
+		resp, err := http.Get("http://" + f.validation + "/.well-known/acme-challenge/tok")
+		if err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			f.authzValid = strings.HasPrefix(string(body), "tok.")
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"type": "http-01", "url": base + "/chal", "token": "tok", "status": "processing"})
+	case "/finalize":
+		var req struct {
+			CSR string `json:"csr"`
+		}
+		_ = json.Unmarshal(payload, &req)
+		raw, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(raw)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		leaf := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      csr.Subject,
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, leaf, f.caCert, csr.PublicKey, f.caKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		f.leafPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+		w.Header().Set("Location", base+"/order/1")
+		writeJSON(w, http.StatusOK, order("valid"))
+	case "/cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_, _ = w.Write(f.leafPEM)
+		_, _ = w.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.caCert.Raw}))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeACME) jwsPayload(r *http.Request) []byte {
+	var jws struct {
+		Payload string `json:"payload"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return nil
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	return payload
+}
+
+func freeLocalAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestRenewCertificate_IssuesFromACMEDirectory(t *testing.T) {
+	mgr := newTestManager(t)
+	challengeAddr := freeLocalAddr(t)
+	ca := newFakeACME(t, mgr.cfg.TLSServerName, challengeAddr)
+
+	mgr.cfg.TLSMode = TLSModeACME
+	mgr.cfg.ACMEDirectoryURL = ca.srv.URL + "/dir"
+	mgr.cfg.ACMEChallenge = ACMEChallengeHTTP01
+	mgr.cfg.ACMEBind = challengeAddr
+	mgr.cfg.CertRenewBefore = 30 * 24 * time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	renewed, err := mgr.RenewCertificate(ctx)
+	if err != nil {
+		t.Fatalf("renew certificate: %v", err)
+	}
+	if !renewed {
+		t.Fatalf("self-signed placeholder must be replaced")
+	}
+
+	cert, err := loadCertificate(mgr.cfg.TLSCertPath)
+	if err != nil {
+		t.Fatalf("load issued certificate: %v", err)
+	}
+	if err := cert.CheckSignatureFrom(ca.caCert); err != nil {
+		t.Fatalf("certificate must be issued by the ACME CA: %v", err)
+	}
+	if err := cert.VerifyHostname(mgr.cfg.TLSServerName); err != nil {
+		t.Fatalf("certificate must cover the server name: %v", err)
+	}
+
+	renewed, err = mgr.RenewCertificate(ctx)
+	if err != nil || renewed {
+		t.Fatalf("fresh certificate must not be renewed again: renewed=%v err=%v", renewed, err)
+	}
+}
+
+func TestACMERenewalDue(t *testing.T) {
+	mgr := newTestManager(t)
+	now := time.Now()
+	if !acmeRenewalDue(mgr.cfg.TLSCertPath, mgr.cfg.TLSServerName, now, time.Hour) {
+		t.Fatalf("self-signed certificate must be due for ACME issuance")
+	}
+	if !acmeRenewalDue(mgr.cfg.TLSCertPath+".missing", mgr.cfg.TLSServerName, now, time.Hour) {
+		t.Fatalf("missing certificate must be due")
+	}
+}
+
+func TestCheckACMEBind_RejectsSingBoxPort(t *testing.T) {
+	cfg := Config{
+		ListenPort:    443,
+		TLSMode:       TLSModeACME,
+		ACMEChallenge: ACMEChallengeTLSALPN01,
+		ACMEBind:      defaultACMEBind(ACMEChallengeTLSALPN01),
+	}
+	if err := cfg.checkACMEBind(); err == nil || !strings.Contains(err.Error(), "vless-in") {
+		t.Fatalf("tls-alpn-01 on the sing-box port must be refused, got %v", err)
+	}
+
+	cfg.ACMEBind = ":8443"
+	if err := cfg.checkACMEBind(); err != nil {
+		t.Fatalf("a free port must be accepted: %v", err)
+	}
+
+	cfg.ACMEChallenge = ACMEChallengeHTTP01
+	cfg.ACMEBind = defaultACMEBind(ACMEChallengeHTTP01)
+	if err := cfg.checkACMEBind(); err != nil {
+		t.Fatalf("the http-01 default must be accepted: %v", err)
+	}
+}
@@ -1,11 +1,15 @@
 package vpnserver
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -24,6 +28,13 @@ func NewApp(cfg Config, logger *log.Logger) *App {
 }
 
 func (a *App) Run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return a.run(ctx)
+}
+
+func (a *App) run(ctx context.Context) error {
 	if err := a.manager.InitState(); err != nil {
 		return fmt.Errorf("state init failed: %w", err)
 	}
@@ -34,6 +45,15 @@ func (a *App) Run() error {
 		}
 	}
 
+	go a.runExpiryLoop(ctx)
+	go a.runCertificateLoop(ctx)
+	if a.cfg.StatsAPIListen != "" {
+		go a.runTrafficLoop(ctx)
+	}
+	if a.cfg.ClashAPIListen != "" {
+		go a.runDeviceLimitLoop(ctx)
+	}
+
 	server := &http.Server{
 		Addr:              a.cfg.APIBind,
 		Handler:           NewHTTPHandler(a.manager, a.logger),
@@ -43,13 +63,138 @@ func (a *App) Run() error {
 	if strings.TrimSpace(a.cfg.APIToken) == "" {
 		a.logger.Printf("API_TOKEN is empty: non-local API calls require tokenless trusted-local access only")
 	}
-	if a.cfg.ClientInsecureTLS {
+	if a.cfg.ClientInsecureTLS && !a.cfg.ClientPinCert {
 		a.logger.Printf("WARNING: VLESS_CLIENT_INSECURE_TLS=true (clients skip TLS certificate verification)")
 	}
 
-	a.logger.Printf("VPN manager API listening on %s", a.cfg.APIBind)
-	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		return fmt.Errorf("http server failed: %w", err)
+	for _, in := range a.cfg.statusInbounds() {
+		a.logger.Printf("inbound %s: %s %s on port %d", in.Tag, in.Protocol, in.Transport, in.Port)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		a.logger.Printf("VPN manager API listening on %s", a.cfg.APIBind)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	var runErr error
+	select {
+	case err := <-serveErr:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			runErr = fmt.Errorf("http server failed: %w", err)
+		}
+	case <-ctx.Done():
+		a.logger.Printf("shutdown requested, draining API (timeout %s)", a.cfg.APIShutdownTimeout)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), a.cfg.APIShutdownTimeout)
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			runErr = fmt.Errorf("http server shutdown: %w", err)
+		}
+		cancel()
+	}
+
+	if err := a.manager.StopInterface(); err != nil && runErr == nil {
+		runErr = fmt.Errorf("stop sing-box: %w", err)
+	}
+	a.logger.Printf("VPN manager stopped")
+	return runErr
+}
+
+func (a *App) runExpiryLoop(ctx context.Context) {
+	interval := a.cfg.ExpiryCheckInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := a.manager.EnforceExpiry()
+		if err != nil {
+			a.logger.Printf("client expiry check failed: %v", err)
+			continue
+		}
+		if changed {
+			a.logger.Printf("client expiry check updated sing-box users")
+		}
+	}
+}
+
+func (a *App) runCertificateLoop(ctx context.Context) {
+	interval := a.cfg.CertCheckInterval
+	if interval <= 0 {
+		interval = 12 * time.Hour
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		next := interval
+		renewed, err := a.manager.RenewCertificate(ctx)
+		switch {
+		case err != nil:
+			a.logger.Printf("certificate renewal failed: %v", err)
+			next = min(interval, acmeRetryDelay)
+		case renewed:
+			a.logger.Printf("certificate renewed, next check in %s", interval)
+		}
+		timer.Reset(next)
+	}
+}
+
+func (a *App) runTrafficLoop(ctx context.Context) {
+	interval := a.cfg.StatsPollInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := a.manager.PollTraffic(ctx); err != nil {
+			a.logger.Printf("traffic stats poll failed: %v", err)
+		}
+	}
+}
+
+func (a *App) runDeviceLimitLoop(ctx context.Context) {
+	interval := a.cfg.DeviceCheckInterval
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := a.manager.EnforceDeviceLimits(ctx); err != nil {
+			a.logger.Printf("device limit check failed: %v", err)
+		}
 	}
-	return nil
 }
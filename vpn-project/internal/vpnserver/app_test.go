@@ -0,0 +1,35 @@
+package vpnserver
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestAppRun_ReturnsCleanlyOnShutdownSignal(t *testing.T) {
+	mgr := newTestManager(t)
+	cfg := mgr.cfg
+	cfg.APIBind = "127.0.0.1:0"
+	cfg.APIShutdownTimeout = time.Second
+	cfg.ExpiryCheckInterval = time.Hour
+
+	app := &App{cfg: cfg, logger: log.New(io.Discard, "", 0), manager: mgr}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- app.run(ctx) }()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("got err %v, want clean shutdown", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("app did not shut down")
+	}
+}
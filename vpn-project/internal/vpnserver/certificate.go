@@ -0,0 +1,429 @@
+package vpnserver
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+type StatusCertificate struct {
+	Subject    string    `json:"subject"`
+	SANs       []string  `json:"sans"`
+	KeyType    string    `json:"key_type"`
+	NotAfter   time.Time `json:"not_after"`
+	DaysLeft   int       `json:"days_left"`
+	SelfSigned bool      `json:"self_signed"`
+}
+
+type certOptions struct {
+	CommonName string
+	SANs       []string
+	KeyType    string
+	Validity   time.Duration
+}
+
+// RenewCertificate keeps the TLS certificate fresh: ACME certificates are
+// re-issued, self-signed ones are regenerated CertRenewBefore ahead of expiry
+// and anything else only produces a warning.
+func (m *Manager) RenewCertificate(ctx context.Context) (bool, error) {
+	if m.cfg.TLSMode == TLSModeACME {
+		return m.renewACMECertificate(ctx)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rotateSelfSignedCertificateLocked(time.Now())
+}
+
+func (m *Manager) rotateSelfSignedCertificateLocked(now time.Time) (bool, error) {
+	cert, err := loadCertificate(m.cfg.TLSCertPath)
+	if err != nil {
+		return false, fmt.Errorf("load tls certificate: %w", err)
+	}
+	left := cert.NotAfter.Sub(now)
+	opts := m.cfg.selfSignedCertOptions()
+	if isSelfSigned(cert) && m.cfg.CertAutoRotate && left > m.cfg.CertRenewBefore && !certMatchesOptions(cert, opts) {
+		// Regenerating changes the pin every issued client config carries, so
+		// a certificate the operator supplied is never replaced for this.
+		if !m.generatedCertificate(cert) {
+			m.logger.Printf("WARNING: self-signed TLS certificate %s does not match configured key type or SANs; it was not generated by the manager and is left as is", m.cfg.TLSCertPath)
+		} else {
+			m.logger.Printf("self-signed TLS certificate does not match configured key type or SANs, regenerating")
+			return true, m.regenerateSelfSignedCertificateLocked()
+		}
+	}
+	if left > m.cfg.CertRenewBefore {
+		return false, nil
+	}
+	// Only certificates the manager generated are replaced; an operator's
+	// own self-signed certificate and key are left alone like any other.
+	if !isSelfSigned(cert) || !m.cfg.CertAutoRotate || !m.generatedCertificate(cert) {
+		m.logger.Printf("WARNING: TLS certificate %s expires at %s (%d days left), replace it manually",
+			m.cfg.TLSCertPath, cert.NotAfter.Format(time.RFC3339), daysLeft(cert, now))
+		return false, nil
+	}
+
+	m.logger.Printf("self-signed TLS certificate expires at %s (%d days left), regenerating",
+		cert.NotAfter.Format(time.RFC3339), daysLeft(cert, now))
+	if err := m.regenerateSelfSignedCertificateLocked(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// regenerateSelfSignedCertificateLocked replaces the certificate, re-issues
+// client configs with the new pin and makes sing-box pick up the new files.
+func (m *Manager) regenerateSelfSignedCertificateLocked() error {
+	if err := m.generateSelfSignedCertificateLocked(); err != nil {
+		return err
+	}
+	if err := m.refreshCertPinLocked(); err != nil {
+		return err
+	}
+
+	clients, err := m.loadClientsLocked()
+	if err != nil {
+		return err
+	}
+	if _, err := m.rewriteServerConfigLocked(clients); err != nil {
+		return err
+	}
+	if err := m.reloadCertificateLocked(); err != nil {
+		return fmt.Errorf("reload sing-box after certificate rotation: %w", err)
+	}
+	if m.cfg.PinnedCertSHA256 != "" {
+		m.logger.Printf("regenerated self-signed TLS certificate (sha256 %s), clients must re-download their configs", m.cfg.PinnedCertSHA256)
+	} else {
+		m.logger.Printf("regenerated self-signed TLS certificate at %s", m.cfg.TLSCertPath)
+	}
+	return nil
+}
+
+// generateSelfSignedCertificateLocked writes a new certificate and records
+// its fingerprint so later checks know the manager owns it.
+func (m *Manager) generateSelfSignedCertificateLocked() error {
+	if err := generateSelfSignedCertificate(m.cfg.TLSCertPath, m.cfg.TLSKeyPath, m.cfg.selfSignedCertOptions()); err != nil {
+		return fmt.Errorf("generate self-signed tls certificate: %w", err)
+	}
+	cert, err := loadCertificate(m.cfg.TLSCertPath)
+	if err != nil {
+		return fmt.Errorf("load tls certificate: %w", err)
+	}
+	if err := writeSecretFile(m.generatedCertPath(), []byte(certFingerprint(cert)+"\n")); err != nil {
+		return fmt.Errorf("record generated certificate: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) generatedCertPath() string {
+	return filepath.Join(m.cfg.StateDir, "self-signed.sha256")
+}
+
+func (m *Manager) generatedCertificate(cert *x509.Certificate) bool {
+	raw, err := os.ReadFile(m.generatedCertPath())
+	return err == nil && strings.TrimSpace(string(raw)) == certFingerprint(cert)
+}
+
+// adoptLegacyCertificateLocked records certificates generated before the
+// self-signed.sha256 marker existed, so upgraded installs keep rotating them.
+// Earlier versions always wrote an RSA-2048 key in PKCS#1 form and a one-year
+// certificate for the common name, localhost and 127.0.0.1; anything else is
+// treated as the operator's own.
+func (m *Manager) adoptLegacyCertificateLocked() error {
+	if fileExists(m.generatedCertPath()) {
+		return nil
+	}
+	cert, err := loadCertificate(m.cfg.TLSCertPath)
+	if err != nil || !isSelfSigned(cert) || !matchesLegacyProfile(cert, m.cfg.certCommonName()) {
+		return nil
+	}
+	rawKey, err := os.ReadFile(m.cfg.TLSKeyPath)
+	if err != nil {
+		return nil
+	}
+	block, _ := pem.Decode(rawKey)
+	if block == nil || block.Type != "RSA PRIVATE KEY" {
+		return nil
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil || !key.PublicKey.Equal(cert.PublicKey) {
+		return nil
+	}
+
+	if err := writeSecretFile(m.generatedCertPath(), []byte(certFingerprint(cert)+"\n")); err != nil {
+		return fmt.Errorf("record generated certificate: %w", err)
+	}
+	m.logger.Printf("adopted self-signed TLS certificate %s generated by an earlier version", m.cfg.TLSCertPath)
+	return nil
+}
+
+func matchesLegacyProfile(cert *x509.Certificate, commonName string) bool {
+	rsaKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok || rsaKey.N.BitLen() != 2048 {
+		return false
+	}
+	if len(cert.Subject.Names) != 1 || cert.Subject.CommonName != commonName || cert.IsCA {
+		return false
+	}
+	if cert.NotAfter.Sub(cert.NotBefore) != 365*24*time.Hour ||
+		cert.KeyUsage != x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment ||
+		!slices.Equal(cert.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}) {
+		return false
+	}
+	want := []string{commonName, "localhost", "127.0.0.1"}
+	got := certSANs(cert)
+	slices.Sort(want)
+	slices.Sort(got)
+	return slices.Equal(slices.Compact(want), slices.Compact(got))
+}
+
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func (m *Manager) certificateStatusLocked(now time.Time) *StatusCertificate {
+	cert, err := loadCertificate(m.cfg.TLSCertPath)
+	if err != nil {
+		return nil
+	}
+	return &StatusCertificate{
+		Subject:    cert.Subject.CommonName,
+		SANs:       certSANs(cert),
+		KeyType:    certKeyType(cert),
+		NotAfter:   cert.NotAfter.UTC(),
+		DaysLeft:   daysLeft(cert, now),
+		SelfSigned: isSelfSigned(cert),
+	}
+}
+
+// ErrCertificateNotGenerated guards a self-signed certificate the manager did
+// not write: regenerating it would overwrite the operator's certificate and key.
+var ErrCertificateNotGenerated = errors.New("TLS certificate was not generated by the manager, pass force=true to overwrite it")
+
+// RegenerateCertificate forces a new self-signed certificate, or a new ACME
+// order in acme mode, regardless of the current expiry. A certificate the
+// manager did not generate is only replaced with force.
+func (m *Manager) RegenerateCertificate(ctx context.Context, force bool) (*StatusCertificate, error) {
+	if m.cfg.TLSMode == TLSModeACME {
+		if _, err := m.issueACMECertificate(ctx); err != nil {
+			return nil, err
+		}
+	} else {
+		m.mu.Lock()
+		err := m.regenerateOwnCertificateLocked(force)
+		m.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.certificateStatusLocked(time.Now()), nil
+}
+
+func (m *Manager) regenerateOwnCertificateLocked(force bool) error {
+	if cert, err := loadCertificate(m.cfg.TLSCertPath); err == nil && !force && !m.generatedCertificate(cert) {
+		return ErrCertificateNotGenerated
+	}
+	return m.regenerateSelfSignedCertificateLocked()
+}
+
+func (c Config) certCommonName() string {
+	return firstNonEmpty(strings.TrimSpace(c.TLSServerName), strings.TrimSpace(c.EndpointHost), "localhost")
+}
+
+func (c Config) selfSignedCertOptions() certOptions {
+	commonName := c.certCommonName()
+	sans := append([]string{commonName}, c.TLSCertSANs...)
+	sans = append(sans, "localhost", "127.0.0.1")
+
+	validity := c.TLSCertValidity
+	if validity <= 0 {
+		validity = 365 * 24 * time.Hour
+	}
+	return certOptions{
+		CommonName: commonName,
+		SANs:       sans,
+		KeyType:    normalizeKeyType(c.TLSKeyType),
+		Validity:   validity,
+	}
+}
+
+func generateSelfSignedCertificate(certPath, keyPath string, opts certOptions) error {
+	if err := os.MkdirAll(filepath.Dir(certPath), 0o700); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0o700); err != nil {
+		return err
+	}
+
+	var (
+		privateKey crypto.Signer
+		keyPEM     []byte
+		err        error
+	)
+	keyUsage := x509.KeyUsageDigitalSignature
+	switch opts.KeyType {
+	case KeyTypeECDSA:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeEd25519:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		var rsaKey *rsa.PrivateKey
+		rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		if err == nil {
+			privateKey = rsaKey
+			keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+		}
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	if err != nil {
+		return err
+	}
+	if keyPEM == nil {
+		der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			return err
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	}
+
+	serialLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serial, err := rand.Int(rand.Reader, serialLimit)
+	if err != nil {
+		return err
+	}
+
+	notBefore := time.Now().Add(-1 * time.Hour)
+	tpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: opts.CommonName,
+		},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(opts.Validity),
+		KeyUsage:              keyUsage,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	seen := map[string]bool{}
+	for _, san := range opts.SANs {
+		if san == "" || seen[san] {
+			continue
+		}
+		seen[san] = true
+		if ip := net.ParseIP(san); ip != nil {
+			tpl.IPAddresses = append(tpl.IPAddresses, ip)
+		} else {
+			tpl.DNSNames = append(tpl.DNSNames, san)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, privateKey.Public(), privateKey)
+	if err != nil {
+		return err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	if err := writeSecretFile(keyPath, keyPEM); err != nil {
+		return err
+	}
+	if err := writeSecretFile(certPath, certPEM); err != nil {
+		return err
+	}
+	return nil
+}
+
+func certMatchesOptions(cert *x509.Certificate, opts certOptions) bool {
+	if certKeyType(cert) != opts.KeyType {
+		return false
+	}
+	for _, san := range opts.SANs {
+		if san != "" && cert.VerifyHostname(san) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func certKeyType(cert *x509.Certificate) string {
+	switch cert.PublicKeyAlgorithm {
+	case x509.ECDSA:
+		return KeyTypeECDSA
+	case x509.Ed25519:
+		return KeyTypeEd25519
+	default:
+		return KeyTypeRSA
+	}
+}
+
+func certSANs(cert *x509.Certificate) []string {
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	return sans
+}
+
+func daysLeft(cert *x509.Certificate, now time.Time) int {
+	return int(cert.NotAfter.Sub(now).Hours() / 24)
+}
+
+// refreshCertPinLocked lets clients trust the manager's own self-signed
+// certificate by value instead of skipping verification.
+// The pin lives in m.cfg, so it is only read and written under m.mu.
+func (m *Manager) refreshCertPinLocked() error {
+	var pinPEM, pinSHA256 string
+	if m.cfg.ClientPinCert && m.cfg.TLSMode != TLSModeACME {
+		cert, err := loadCertificate(m.cfg.TLSCertPath)
+		if err != nil {
+			return fmt.Errorf("load tls certificate: %w", err)
+		}
+		if isSelfSigned(cert) {
+			pinPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+			pinSHA256 = certFingerprint(cert)
+		}
+	}
+
+	m.cfg.PinnedCertPEM = pinPEM
+	m.cfg.PinnedCertSHA256 = pinSHA256
+	return nil
+}
+
+func loadCertificate(path string) (*x509.Certificate, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s: no PEM certificate", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) &&
+		cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
@@ -0,0 +1,340 @@
+package vpnserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotateSelfSignedCertificate_RegeneratesAndRepinsClients(t *testing.T) {
+	mgr := newTestManager(t)
+	mgr.cfg.ClientPinCert = true
+	mgr.cfg.CertAutoRotate = true
+	mgr.cfg.CertRenewBefore = 30 * 24 * time.Hour
+	if err := mgr.refreshCertPinLocked(); err != nil {
+		t.Fatalf("refresh pin: %v", err)
+	}
+	oldPin := mgr.cfg.PinnedCertSHA256
+
+	c, _, err := mgr.CreateClient("alice", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	status := mgr.certificateStatusLocked(time.Now())
+	if status == nil || !status.SelfSigned || status.DaysLeft < 360 {
+		t.Fatalf("unexpected certificate status: %#v", status)
+	}
+
+	rotated, err := mgr.rotateSelfSignedCertificateLocked(time.Now())
+	if err != nil || rotated {
+		t.Fatalf("fresh certificate must not rotate: rotated=%v err=%v", rotated, err)
+	}
+
+	rotated, err = mgr.rotateSelfSignedCertificateLocked(time.Now().Add(340 * 24 * time.Hour))
+	if err != nil {
+		t.Fatalf("rotate certificate: %v", err)
+	}
+	if !rotated {
+		t.Fatalf("certificate within the renewal window must rotate")
+	}
+	if mgr.cfg.PinnedCertSHA256 == oldPin {
+		t.Fatalf("rotation must change the pinned fingerprint")
+	}
+
+	raw, err := os.ReadFile(c.ConfigPath)
+	if err != nil {
+		t.Fatalf("read client config: %v", err)
+	}
+	newCert, err := os.ReadFile(mgr.cfg.TLSCertPath)
+	if err != nil {
+		t.Fatalf("read certificate: %v", err)
+	}
+	if !strings.Contains(string(raw), strings.Split(strings.TrimSpace(string(newCert)), "\n")[1]) {
+		t.Fatalf("client config must be re-issued with the new certificate")
+	}
+}
+
+func TestRotateSelfSignedCertificate_DisabledOnlyWarns(t *testing.T) {
+	mgr := newTestManager(t)
+	mgr.cfg.CertRenewBefore = 30 * 24 * time.Hour
+	before, err := os.ReadFile(mgr.cfg.TLSCertPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := mgr.rotateSelfSignedCertificateLocked(time.Now().Add(400 * 24 * time.Hour))
+	if err != nil || rotated {
+		t.Fatalf("rotation disabled: rotated=%v err=%v", rotated, err)
+	}
+	after, _ := os.ReadFile(mgr.cfg.TLSCertPath)
+	if string(before) != string(after) {
+		t.Fatalf("certificate must stay untouched when auto rotation is off")
+	}
+}
+
+func TestGenerateSelfSignedCertificate_KeyTypesSANsAndValidity(t *testing.T) {
+	dir := t.TempDir()
+	for _, keyType := range []string{KeyTypeRSA, KeyTypeECDSA, KeyTypeEd25519} {
+		certPath := filepath.Join(dir, keyType+".crt")
+		keyPath := filepath.Join(dir, keyType+".key")
+		opts := certOptions{
+			CommonName: "vpn.example.com",
+			SANs:       []string{"vpn.example.com", "alt.example.net", "203.0.113.7", "vpn.example.com"},
+			KeyType:    keyType,
+			Validity:   30 * 24 * time.Hour,
+		}
+		if err := generateSelfSignedCertificate(certPath, keyPath, opts); err != nil {
+			t.Fatalf("%s: generate: %v", keyType, err)
+		}
+		if _, err := tls.LoadX509KeyPair(certPath, keyPath); err != nil {
+			t.Fatalf("%s: key pair must load: %v", keyType, err)
+		}
+
+		cert, err := loadCertificate(certPath)
+		if err != nil {
+			t.Fatalf("%s: load: %v", keyType, err)
+		}
+		if certKeyType(cert) != keyType || !certMatchesOptions(cert, opts) {
+			t.Fatalf("%s: certificate must match options, sans=%v", keyType, certSANs(cert))
+		}
+		if len(cert.DNSNames) != 2 || len(cert.IPAddresses) != 1 {
+			t.Fatalf("%s: unexpected SANs %v", keyType, certSANs(cert))
+		}
+		if d := cert.NotAfter.Sub(cert.NotBefore); d != opts.Validity {
+			t.Fatalf("%s: validity %s, want %s", keyType, d, opts.Validity)
+		}
+	}
+}
+
+func TestRegenerateCertificateEndpoint_AppliesNewSANs(t *testing.T) {
+	mgr := newTestManager(t)
+	mgr.cfg.TLSKeyType = KeyTypeECDSA
+	mgr.cfg.TLSCertSANs = []string{"second.example.com", "198.51.100.4"}
+
+	handler := NewHTTPHandler(mgr, log.New(io.Discard, "", 0))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/certificate/regenerate", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp struct {
+		Certificate StatusCertificate `json:"certificate"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Certificate.KeyType != KeyTypeECDSA || !slices.Contains(resp.Certificate.SANs, "second.example.com") ||
+		!slices.Contains(resp.Certificate.SANs, "198.51.100.4") {
+		t.Fatalf("unexpected certificate: %#v", resp.Certificate)
+	}
+}
+
+func TestRegenerateCertificate_ConfigAndShareURIKeepTheSamePin(t *testing.T) {
+	mgr := newTestManager(t)
+	mgr.cfg.TLSKeyType = KeyTypeECDSA
+	mgr.cfg.ClientPinCert = true
+	if err := mgr.refreshCertPinLocked(); err != nil {
+		t.Fatalf("refresh pin: %v", err)
+	}
+	c, _, err := mgr.CreateClient("alice", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	handler := NewHTTPHandler(mgr, log.New(io.Discard, "", 0))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			req := httptest.NewRequest(http.MethodPost, "http://localhost/certificate/regenerate", nil)
+			req.RemoteAddr = "127.0.0.1:1234"
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		code, resp := fetchClientConfig(t, mgr, c.ID, "")
+		if code != http.StatusOK {
+			t.Fatalf("got status %d: %v", code, resp)
+		}
+		var config struct {
+			Outbounds []struct {
+				TLS struct {
+					Certificate string `json:"certificate"`
+				} `json:"tls"`
+			} `json:"outbounds"`
+		}
+		if err := json.Unmarshal([]byte(resp["config"].(string)), &config); err != nil {
+			t.Fatalf("decode config: %v", err)
+		}
+		block, _ := pem.Decode([]byte(config.Outbounds[0].TLS.Certificate))
+		if block == nil {
+			t.Fatalf("config must embed the pinned certificate")
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatalf("parse pinned certificate: %v", err)
+		}
+		if uri := resp["share_uri"].(string); !strings.Contains(uri, "pcs="+certFingerprint(cert)) {
+			t.Fatalf("share uri must carry the pin of the returned config: %s", uri)
+		}
+	}
+	<-done
+}
+
+func TestRotateSelfSignedCertificate_KeepsOperatorCertificateOnMismatch(t *testing.T) {
+	mgr := newTestManager(t)
+	mgr.cfg.CertAutoRotate = true
+	mgr.cfg.CertRenewBefore = 30 * 24 * time.Hour
+	mgr.cfg.TLSKeyType = KeyTypeECDSA
+
+	// The manager generated the current certificate, so a key type change
+	// may replace it.
+	rotated, err := mgr.rotateSelfSignedCertificateLocked(time.Now())
+	if err != nil || !rotated {
+		t.Fatalf("managed certificate must follow the configured key type: rotated=%v err=%v", rotated, err)
+	}
+
+	// An operator-supplied self-signed certificate without the injected
+	// localhost SANs must survive every check.
+	opts := certOptions{CommonName: "vpn.example.com", SANs: []string{"vpn.example.com"}, KeyType: KeyTypeRSA, Validity: 365 * 24 * time.Hour}
+	if err := generateSelfSignedCertificate(mgr.cfg.TLSCertPath, mgr.cfg.TLSKeyPath, opts); err != nil {
+		t.Fatalf("write operator certificate: %v", err)
+	}
+	before, err := os.ReadFile(mgr.cfg.TLSCertPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err = mgr.rotateSelfSignedCertificateLocked(time.Now())
+	if err != nil || rotated {
+		t.Fatalf("operator certificate must not be regenerated: rotated=%v err=%v", rotated, err)
+	}
+	after, _ := os.ReadFile(mgr.cfg.TLSCertPath)
+	if string(before) != string(after) {
+		t.Fatalf("operator certificate was replaced")
+	}
+}
+
+func TestRotateSelfSignedCertificate_KeepsOperatorCertificateNearExpiry(t *testing.T) {
+	mgr := newTestManager(t)
+	mgr.cfg.CertAutoRotate = true
+	mgr.cfg.CertRenewBefore = 30 * 24 * time.Hour
+
+	opts := mgr.cfg.selfSignedCertOptions()
+	if err := generateSelfSignedCertificate(mgr.cfg.TLSCertPath, mgr.cfg.TLSKeyPath, opts); err != nil {
+		t.Fatalf("write operator certificate: %v", err)
+	}
+	before, err := os.ReadFile(mgr.cfg.TLSKeyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := mgr.rotateSelfSignedCertificateLocked(time.Now().Add(340 * 24 * time.Hour))
+	if err != nil || rotated {
+		t.Fatalf("operator certificate must only produce a warning: rotated=%v err=%v", rotated, err)
+	}
+	after, _ := os.ReadFile(mgr.cfg.TLSKeyPath)
+	if string(before) != string(after) {
+		t.Fatalf("operator key was replaced")
+	}
+}
+
+func TestInitState_AdoptsCertificateFromEarlierVersions(t *testing.T) {
+	mgr := newTestManager(t)
+	mgr.cfg.CertAutoRotate = true
+	mgr.cfg.CertRenewBefore = 30 * 24 * time.Hour
+
+	// Earlier versions generated the same profile but wrote no marker.
+	if err := os.Remove(mgr.generatedCertPath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.InitState(); err != nil {
+		t.Fatalf("init state: %v", err)
+	}
+	cert, err := loadCertificate(mgr.cfg.TLSCertPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mgr.generatedCertificate(cert) {
+		t.Fatalf("a certificate from an earlier version must be adopted")
+	}
+	rotated, err := mgr.rotateSelfSignedCertificateLocked(time.Now().Add(340 * 24 * time.Hour))
+	if err != nil || !rotated {
+		t.Fatalf("adopted certificate must rotate: rotated=%v err=%v", rotated, err)
+	}
+}
+
+func TestInitState_DoesNotAdoptOperatorCertificate(t *testing.T) {
+	mgr := newTestManager(t)
+	opts := mgr.cfg.selfSignedCertOptions()
+	opts.SANs = append(opts.SANs, "vpn.example.org")
+	if err := generateSelfSignedCertificate(mgr.cfg.TLSCertPath, mgr.cfg.TLSKeyPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(mgr.generatedCertPath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.InitState(); err != nil {
+		t.Fatalf("init state: %v", err)
+	}
+	if fileExists(mgr.generatedCertPath()) {
+		t.Fatalf("a certificate with a different profile must stay the operator's")
+	}
+}
+
+func TestRegenerateCertificateEndpoint_KeepsOperatorCertificateUnlessForced(t *testing.T) {
+	mgr := newTestManager(t)
+	opts := mgr.cfg.selfSignedCertOptions()
+	opts.KeyType = KeyTypeECDSA
+	if err := generateSelfSignedCertificate(mgr.cfg.TLSCertPath, mgr.cfg.TLSKeyPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	operatorCert, err := os.ReadFile(mgr.cfg.TLSCertPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewHTTPHandler(mgr, log.New(io.Discard, "", 0))
+	regenerate := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/certificate/regenerate"+query, nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := regenerate(""); rec.Code != http.StatusConflict {
+		t.Fatalf("operator certificate must not be overwritten, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if raw, _ := os.ReadFile(mgr.cfg.TLSCertPath); string(raw) != string(operatorCert) {
+		t.Fatalf("refused regeneration must leave the certificate untouched")
+	}
+	if rec := regenerate("?force=maybe"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid force flag must be rejected, got %d", rec.Code)
+	}
+
+	if rec := regenerate("?force=true"); rec.Code != http.StatusOK {
+		t.Fatalf("forced regeneration: got %d: %s", rec.Code, rec.Body.String())
+	}
+	cert, err := loadCertificate(mgr.cfg.TLSCertPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certKeyType(cert) != KeyTypeRSA || !mgr.generatedCertificate(cert) {
+		t.Fatalf("forced regeneration must replace the certificate with the manager's own")
+	}
+}
@@ -0,0 +1,178 @@
+package vpnserver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const clashProxyGroup = "PROXY"
+
+func (vlessProtocol) clashProxy(cfg Config, name string, c Client) map[string]any {
+	proxy := clashBaseProxy(cfg, name, "vless")
+	proxy["uuid"] = c.UUID
+	proxy["tls"] = true
+	if flow := cfg.vlessFlow(); flow != "" {
+		proxy["flow"] = flow
+	}
+	setClashTLS(proxy, cfg, "servername")
+	setClashTransport(proxy, cfg)
+	return proxy
+}
+
+func (trojanProtocol) clashProxy(cfg Config, name string, c Client) map[string]any {
+	proxy := clashBaseProxy(cfg, name, "trojan")
+	proxy["password"] = c.Password
+	setClashTLS(proxy, cfg, "sni")
+	setClashTransport(proxy, cfg)
+	return proxy
+}
+
+func (shadowsocksProtocol) clashProxy(cfg Config, name string, c Client) map[string]any {
+	proxy := clashBaseProxy(cfg, name, "ss")
+	proxy["cipher"] = cfg.ShadowsocksMethod
+	proxy["password"] = shadowsocksClientPassword(cfg, c)
+	return proxy
+}
+
+func (hysteria2Protocol) clashProxy(cfg Config, name string, c Client) map[string]any {
+	proxy := clashBaseProxy(cfg, name, "hysteria2")
+	proxy["password"] = c.Password
+	proxy["alpn"] = []string{"h3"}
+	setClashTLS(proxy, cfg, "sni")
+	return proxy
+}
+
+func clashBaseProxy(cfg Config, name, proxyType string) map[string]any {
+	host, port := resolveEndpointHostPort(cfg.EndpointHost, cfg.ListenPort)
+	return map[string]any{
+		"name":   name,
+		"type":   proxyType,
+		"server": host,
+		"port":   port,
+		"udp":    true,
+	}
+}
+
+// setClashTLS uses mihomo's option names; sniKey differs between proxy types.
+func setClashTLS(proxy map[string]any, cfg Config, sniKey string) {
+	if cfg.securityType() == SecurityReality {
+		proxy[sniKey] = cfg.RealityServerName
+		proxy["client-fingerprint"] = cfg.RealityFingerprint
+		proxy["reality-opts"] = map[string]any{
+			"public-key": cfg.RealityPublicKey,
+			"short-id":   firstRealityShortID(cfg),
+		}
+		return
+	}
+	if strings.TrimSpace(cfg.TLSServerName) != "" {
+		proxy[sniKey] = cfg.TLSServerName
+	}
+	if cfg.PinnedCertSHA256 != "" {
+		proxy["fingerprint"] = cfg.PinnedCertSHA256
+	} else if cfg.ClientInsecureTLS {
+		proxy["skip-cert-verify"] = true
+	}
+}
+
+func setClashTransport(proxy map[string]any, cfg Config) {
+	switch cfg.transportType() {
+	case TransportGRPC:
+		proxy["network"] = "grpc"
+		proxy["grpc-opts"] = map[string]any{"grpc-service-name": cfg.GRPCServiceName}
+	case TransportHTTPUpgrade:
+		proxy["network"] = "ws"
+		proxy["ws-opts"] = map[string]any{"path": cfg.WebsocketPath, "v2ray-http-upgrade": true}
+	case TransportTCP:
+		proxy["network"] = "tcp"
+	default:
+		proxy["network"] = "ws"
+		proxy["ws-opts"] = map[string]any{"path": cfg.WebsocketPath}
+	}
+}
+
+// buildClashConfig renders a Clash Meta (mihomo) profile with the same
+// routing as buildClientConfigMap: private ranges direct, the rest proxied.
+func buildClashConfig(cfg Config, c Client) yamlMap {
+	inbounds := cfg.inboundConfigs()
+	proxies := make([]any, 0, len(inbounds))
+	names := make([]string, 0, len(inbounds))
+	for i, in := range inbounds {
+		inCfg := cfg.forInbound(in)
+		name := c.Name
+		if i > 0 {
+			name = fmt.Sprintf("%s (%s)", c.Name, inCfg.inboundLabel())
+		}
+		names = append(names, name)
+		proxies = append(proxies, protocolFor(inCfg).clashProxy(inCfg, name, c))
+	}
+
+	group := map[string]any{
+		"name":    clashProxyGroup,
+		"type":    "select",
+		"proxies": names,
+	}
+	if len(names) > 1 {
+		group["type"] = "url-test"
+		group["url"] = "https://www.gstatic.com/generate_204"
+		group["interval"] = 180
+	}
+
+	tun := map[string]any{
+		"enable":                true,
+		"stack":                 "mixed",
+		"auto-route":            true,
+		"auto-detect-interface": true,
+		"strict-route":          true,
+		"dns-hijack":            []string{"any:53"},
+	}
+	host, _ := resolveEndpointHostPort(cfg.EndpointHost, cfg.ListenPort)
+	if exclude := routeExcludeCIDRsForHost(host); len(exclude) > 0 {
+		tun["route-exclude-address"] = exclude
+	}
+
+	return yamlMap{
+		{"mixed-port", 7890},
+		{"allow-lan", false},
+		{"mode", "rule"},
+		{"log-level", "warning"},
+		{"ipv6", true},
+		{"dns", map[string]any{
+			"enable":        true,
+			"ipv6":          true,
+			"enhanced-mode": "fake-ip",
+			"nameserver":    []string{"https://1.1.1.1/dns-query"},
+		}},
+		{"tun", tun},
+		{"proxies", proxies},
+		{"proxy-groups", []any{group}},
+		{"rules", clashRules(cfg)},
+	}
+}
+
+func clashRules(cfg Config) []string {
+	var rules []string
+	for _, name := range cfg.ClientBlockRuleSets {
+		rules = append(rules, clashRuleSetRule(name, "REJECT"))
+	}
+	for _, cidr := range clientPrivateCIDRs {
+		kind := "IP-CIDR"
+		if ip, _, err := net.ParseCIDR(cidr); err == nil && ip.To4() == nil {
+			kind = "IP-CIDR6"
+		}
+		rules = append(rules, kind+","+cidr+",DIRECT,no-resolve")
+	}
+	for _, name := range cfg.ClientDirectRuleSets {
+		rules = append(rules, clashRuleSetRule(name, "DIRECT"))
+	}
+	return append(rules, "MATCH,"+clashProxyGroup)
+}
+
+// clashRuleSetRule maps sing-box rule-set names onto mihomo's geodata
+// matchers, e.g. "geoip-ru" becomes "GEOIP,ru".
+func clashRuleSetRule(name, target string) string {
+	if code, ok := strings.CutPrefix(name, "geoip-"); ok {
+		return "GEOIP," + code + "," + target
+	}
+	return "GEOSITE," + strings.TrimPrefix(name, "geosite-") + "," + target
+}
@@ -0,0 +1,141 @@
+package vpnserver
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	ClientFormatSingBox       = "singbox"
+	ClientFormatSingBoxRemote = "singbox-remote"
+	ClientFormatClash         = "clash"
+	ClientFormatXray          = "xray"
+)
+
+func parseClientFormat(raw string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", "singbox", "sing-box":
+		return ClientFormatSingBox, nil
+	case "singbox-remote", "sing-box-remote", "remote":
+		return ClientFormatSingBoxRemote, nil
+	case "clash", "clash-meta", "mihomo":
+		return ClientFormatClash, nil
+	case "xray":
+		return ClientFormatXray, nil
+	default:
+		return "", fmt.Errorf("unknown config format %q (use singbox, singbox-remote, clash or xray)", raw)
+	}
+}
+
+func clientFormatContentType(format string) string {
+	if format == ClientFormatClash {
+		return "application/yaml; charset=utf-8"
+	}
+	return "application/json; charset=utf-8"
+}
+
+// GetClientConfigFormat renders the client for another app. The sing-box
+// format is the stored client config and behaves like GetClientConfig.
+func (m *Manager) GetClientConfigFormat(clientID, format string) (Client, ClientProfile, error) {
+	if format == ClientFormatSingBox {
+		return m.GetClientConfig(clientID)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clients, err := m.loadClientsLocked()
+	if err != nil {
+		return Client{}, ClientProfile{}, err
+	}
+	c, ok := clients[clientID]
+	if !ok {
+		return Client{}, ClientProfile{}, os.ErrNotExist
+	}
+
+	config, err := renderClientConfig(m.cfg, c, format)
+	if err != nil {
+		return Client{}, ClientProfile{}, err
+	}
+	return c, m.clientProfileLocked(c, config), nil
+}
+
+func renderClientConfig(cfg Config, c Client, format string) (string, error) {
+	var (
+		payload []byte
+		err     error
+	)
+	switch format {
+	case ClientFormatSingBox:
+		payload, err = marshalPretty(buildClientConfigMap(cfg, c))
+	case ClientFormatSingBoxRemote:
+		payload, err = marshalPretty(buildSingBoxRemoteProfile(cfg, c))
+	case ClientFormatClash:
+		payload, err = marshalYAML(buildClashConfig(cfg, c))
+	case ClientFormatXray:
+		var config map[string]any
+		if config, err = buildXrayConfig(cfg, c); err == nil {
+			payload, err = marshalPretty(config)
+		}
+	default:
+		return "", fmt.Errorf("unknown config format %q", format)
+	}
+	if err != nil {
+		return "", fmt.Errorf("render %s config: %w", format, err)
+	}
+	return string(payload), nil
+}
+
+// buildSingBoxRemoteProfile is the client config for sing-box apps' remote
+// profiles: it adds remote rule-sets from SagerNet's sing-geosite and
+// sing-geoip repos, fetched through the proxy and cached on the device. With
+// no rule sets configured it is the plain sing-box config.
+func buildSingBoxRemoteProfile(cfg Config, c Client) map[string]any {
+	config := buildClientConfigMap(cfg, c)
+	route := config["route"].(map[string]any)
+	rules := route["rules"].([]any)
+
+	var ruleSets []any
+	for _, name := range append(append([]string{}, cfg.ClientBlockRuleSets...), cfg.ClientDirectRuleSets...) {
+		ruleSets = append(ruleSets, map[string]any{
+			"type":            "remote",
+			"tag":             name,
+			"format":          "binary",
+			"url":             ruleSetURL(name),
+			"download_detour": "proxy",
+		})
+	}
+	if len(ruleSets) == 0 {
+		return config
+	}
+
+	// Blocking goes right after DNS hijacking so it wins over direct routes.
+	extra := []any{}
+	if len(cfg.ClientBlockRuleSets) > 0 {
+		extra = append(extra, map[string]any{
+			"rule_set": cfg.ClientBlockRuleSets,
+			"action":   "reject",
+		})
+	}
+	if len(cfg.ClientDirectRuleSets) > 0 {
+		extra = append(extra, map[string]any{
+			"rule_set": cfg.ClientDirectRuleSets,
+			"outbound": "direct",
+		})
+	}
+	route["rules"] = append(append(rules[:1:1], extra...), rules[1:]...)
+	route["rule_set"] = ruleSets
+	config["experimental"] = map[string]any{
+		"cache_file": map[string]any{"enabled": true},
+	}
+	return config
+}
+
+func ruleSetURL(name string) string {
+	repo := "sing-geosite"
+	if strings.HasPrefix(name, "geoip-") {
+		repo = "sing-geoip"
+	}
+	return "https://raw.githubusercontent.com/SagerNet/" + repo + "/rule-set/" + name + ".srs"
+}
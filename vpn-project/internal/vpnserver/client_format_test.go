@@ -0,0 +1,176 @@
+package vpnserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func fetchClientConfig(t *testing.T, mgr *Manager, clientID, format string) (int, map[string]any) {
+	t.Helper()
+	handler := NewHTTPHandler(mgr, log.New(io.Discard, "", 0))
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/clients/"+clientID+"/config?format="+format, nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var resp map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return rec.Code, resp
+}
+
+func TestClientConfigFormats(t *testing.T) {
+	mgr := newTestManager(t)
+	mgr.cfg.ClientBlockRuleSets = []string{"geosite-category-ads-all"}
+	mgr.cfg.ClientDirectRuleSets = []string{"geoip-ru"}
+	c, _, err := mgr.CreateClient("alice", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	code, resp := fetchClientConfig(t, mgr, c.ID, "clash")
+	if code != http.StatusOK || resp["format"] != ClientFormatClash {
+		t.Fatalf("got status %d: %v", code, resp)
+	}
+	if uri, _ := resp["share_uri"].(string); !strings.HasPrefix(uri, "vless://") || resp["vless_uri"] != uri {
+		t.Fatalf("share_uri must carry the link and vless_uri stay as its alias: %v", resp)
+	}
+	clash := resp["config"].(string)
+	for _, want := range []string{
+		"proxies:\n  - name: alice\n    type: vless\n",
+		"    uuid: " + c.UUID + "\n",
+		"    ws-opts:\n      path: /vpn\n",
+		"  - GEOSITE,category-ads-all,REJECT\n",
+		"  - GEOIP,ru,DIRECT\n",
+		"  - MATCH,PROXY\n",
+	} {
+		if !strings.Contains(clash, want) {
+			t.Fatalf("clash profile missing %q:\n%s", want, clash)
+		}
+	}
+
+	_, resp = fetchClientConfig(t, mgr, c.ID, "singbox-remote")
+	var remote struct {
+		Route struct {
+			Rules   []map[string]any `json:"rules"`
+			RuleSet []map[string]any `json:"rule_set"`
+		} `json:"route"`
+	}
+	if err := json.Unmarshal([]byte(resp["config"].(string)), &remote); err != nil {
+		t.Fatalf("decode remote profile: %v", err)
+	}
+	if len(remote.Route.RuleSet) != 2 || remote.Route.RuleSet[1]["url"] != "https://raw.githubusercontent.com/SagerNet/sing-geoip/rule-set/geoip-ru.srs" {
+		t.Fatalf("unexpected rule sets: %v", remote.Route.RuleSet)
+	}
+	if remote.Route.Rules[0]["action"] != "hijack-dns" || remote.Route.Rules[1]["action"] != "reject" {
+		t.Fatalf("block rule must follow dns hijacking: %v", remote.Route.Rules)
+	}
+
+	_, resp = fetchClientConfig(t, mgr, c.ID, "xray")
+	var xray struct {
+		Outbounds []struct {
+			Tag            string         `json:"tag"`
+			Protocol       string         `json:"protocol"`
+			StreamSettings map[string]any `json:"streamSettings"`
+		} `json:"outbounds"`
+	}
+	if err := json.Unmarshal([]byte(resp["config"].(string)), &xray); err != nil {
+		t.Fatalf("decode xray config: %v", err)
+	}
+	if len(xray.Outbounds) != 3 || xray.Outbounds[0].Protocol != "vless" || xray.Outbounds[0].StreamSettings["network"] != "ws" {
+		t.Fatalf("unexpected xray outbounds: %+v", xray.Outbounds)
+	}
+
+	if code, _ := fetchClientConfig(t, mgr, c.ID, "wireguard"); code != http.StatusBadRequest {
+		t.Fatalf("unknown format must be rejected, got %d", code)
+	}
+}
+
+func TestSingBoxRemoteProfile_AddsRuleSetsToTheSingBoxConfig(t *testing.T) {
+	mgr := newTestManager(t)
+	c, _, err := mgr.CreateClient("alice", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	render := func(format string) string {
+		config, err := renderClientConfig(mgr.cfg, c, format)
+		if err != nil {
+			t.Fatalf("render %s: %v", format, err)
+		}
+		return config
+	}
+
+	// With no rule sets there is nothing to fetch, so both formats match.
+	if render(ClientFormatSingBoxRemote) != render(ClientFormatSingBox) {
+		t.Fatalf("without rule sets singbox-remote must equal singbox")
+	}
+
+	mgr.cfg.ClientBlockRuleSets = []string{"geosite-category-ads-all"}
+	mgr.cfg.ClientDirectRuleSets = []string{"geosite-ru"}
+	plain := render(ClientFormatSingBox)
+	if strings.Contains(plain, "rule_set") {
+		t.Fatalf("the singbox format must not fetch rule sets:\n%s", plain)
+	}
+	var remote struct {
+		Route struct {
+			Rules   []map[string]any `json:"rules"`
+			RuleSet []map[string]any `json:"rule_set"`
+		} `json:"route"`
+		Experimental struct {
+			CacheFile struct {
+				Enabled bool `json:"enabled"`
+			} `json:"cache_file"`
+		} `json:"experimental"`
+	}
+	if err := json.Unmarshal([]byte(render(ClientFormatSingBoxRemote)), &remote); err != nil {
+		t.Fatalf("decode remote profile: %v", err)
+	}
+	if len(remote.Route.RuleSet) != 2 || remote.Route.RuleSet[0]["download_detour"] != "proxy" || !remote.Experimental.CacheFile.Enabled {
+		t.Fatalf("remote profile must download rule sets through the proxy and cache them: %+v", remote)
+	}
+	if remote.Route.Rules[1]["action"] != "reject" || remote.Route.Rules[2]["outbound"] != "direct" {
+		t.Fatalf("remote profile must block and bypass the configured rule sets: %v", remote.Route.Rules)
+	}
+}
+
+func TestBuildXrayConfig_SkipsHysteria2(t *testing.T) {
+	cfg := newTestManager(t).cfg
+	cfg.Protocol = ProtocolHysteria2
+	if _, err := buildXrayConfig(cfg, Client{Password: "secret"}); !errors.Is(err, errXrayUnsupported) {
+		t.Fatalf("expected errXrayUnsupported, got %v", err)
+	}
+}
+
+func TestMarshalYAML(t *testing.T) {
+	got, err := marshalYAML(yamlMap{
+		{"port", 7890},
+		{"empty", []any{}},
+		{"list", []any{
+			map[string]any{"type": "ss", "name": "a \"b\"", "alpn": []string{"h3"}},
+			"x",
+		}},
+		{"flag", "yes"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "port: 7890\n" +
+		"empty: []\n" +
+		"list:\n" +
+		"  - name: a \"b\"\n" +
+		"    type: ss\n" +
+		"    alpn:\n" +
+		"      - h3\n" +
+		"  - x\n" +
+		"flag: \"yes\"\n"
+	if string(got) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
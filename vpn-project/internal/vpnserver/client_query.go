@@ -0,0 +1,109 @@
+package vpnserver
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultClientListLimit = 100
+	maxClientListLimit     = 1000
+)
+
+type clientListQuery struct {
+	NamePrefix string
+	Tag        string
+	SortField  string
+	Descending bool
+	Offset     int
+	Limit      int
+}
+
+func parseClientListQuery(values url.Values) (clientListQuery, error) {
+	q := clientListQuery{
+		NamePrefix: strings.TrimSpace(values.Get("prefix")),
+		Tag:        strings.TrimSpace(values.Get("tag")),
+		SortField:  "id",
+		Limit:      defaultClientListLimit,
+	}
+
+	if raw := strings.TrimSpace(values.Get("sort")); raw != "" {
+		if strings.HasPrefix(raw, "-") {
+			q.Descending = true
+			raw = raw[1:]
+		}
+		switch raw {
+		case "id", "name", "created_at":
+			q.SortField = raw
+		default:
+			return clientListQuery{}, fmt.Errorf("unsupported sort field %q", raw)
+		}
+	}
+
+	if raw := strings.TrimSpace(values.Get("offset")); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return clientListQuery{}, fmt.Errorf("invalid offset %q", raw)
+		}
+		q.Offset = offset
+	}
+
+	if raw := strings.TrimSpace(values.Get("limit")); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return clientListQuery{}, fmt.Errorf("invalid limit %q", raw)
+		}
+		if limit > maxClientListLimit {
+			limit = maxClientListLimit
+		}
+		q.Limit = limit
+	}
+
+	return q, nil
+}
+
+func (q clientListQuery) apply(clients []Client) ([]Client, int) {
+	prefix := strings.ToLower(q.NamePrefix)
+	filtered := make([]Client, 0, len(clients))
+	for _, c := range clients {
+		if prefix != "" && !strings.HasPrefix(strings.ToLower(c.Name), prefix) {
+			continue
+		}
+		if q.Tag != "" && !slices.Contains(c.Tags, q.Tag) {
+			continue
+		}
+		filtered = append(filtered, c)
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		a, b := filtered[i], filtered[j]
+		if q.Descending {
+			a, b = b, a
+		}
+		switch q.SortField {
+		case "name":
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+		case "created_at":
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.Before(b.CreatedAt)
+			}
+		}
+		return a.ID < b.ID
+	})
+
+	total := len(filtered)
+	if q.Offset >= total {
+		return []Client{}, total
+	}
+	end := q.Offset + q.Limit
+	if end > total {
+		end = total
+	}
+	return filtered[q.Offset:end], total
+}
@@ -4,6 +4,32 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
+)
+
+const (
+	ProtocolVLESS       = "vless"
+	ProtocolTrojan      = "trojan"
+	ProtocolShadowsocks = "shadowsocks"
+	ProtocolHysteria2   = "hysteria2"
+
+	SecurityTLS     = "tls"
+	SecurityReality = "reality"
+
+	TransportWS          = "ws"
+	TransportGRPC        = "grpc"
+	TransportHTTPUpgrade = "httpupgrade"
+	TransportTCP         = "tcp"
+
+	KeyTypeRSA     = "rsa"
+	KeyTypeECDSA   = "ecdsa"
+	KeyTypeEd25519 = "ed25519"
+
+	TLSModeSelfSigned = "self-signed"
+	TLSModeACME       = "acme"
+
+	ACMEChallengeHTTP01    = "http-01"
+	ACMEChallengeTLSALPN01 = "tls-alpn-01"
 )
 
 type Config struct {
@@ -12,17 +38,74 @@ type Config struct {
 	ListenAddress     string
 	ListenPort        int
 	EndpointHost      string
+	Protocol          string
 	WebsocketPath     string
+	Transport         string
+	GRPCServiceName   string
+	Security          string
 	TLSServerName     string
 	TLSCertPath       string
 	TLSKeyPath        string
+	TLSMode           string
+	TLSKeyType        string
+	TLSCertValidity   time.Duration
+	TLSCertSANs       []string
 	ClientTunName     string
 	ClientTunCIDR     string
 	ClientInsecureTLS bool
+	ClientPinCert     bool
 	SingBoxBinary     string
+	ValidateConfig    bool
 	APIBind           string
 	APIToken          string
 	AutoStart         bool
+
+	RealityHandshakeServer string
+	RealityServerName      string
+	RealityFingerprint     string
+	RealityPrivateKey      string
+	RealityPublicKey       string
+	RealityShortIDs        []string
+
+	PinnedCertPEM    string
+	PinnedCertSHA256 string
+
+	ShadowsocksMethod    string
+	ShadowsocksServerKey string
+
+	ACMEDirectoryURL  string
+	ACMEEmail         string
+	ACMEChallenge     string
+	ACMEBind          string
+	CertRenewBefore   time.Duration
+	CertAutoRotate    bool
+	CertCheckInterval time.Duration
+
+	ExtraInbounds   []InboundConfig
+	SkippedInbounds []string
+
+	StopGracePeriod    time.Duration
+	APIShutdownTimeout time.Duration
+
+	ExpiryCheckInterval time.Duration
+
+	StatsAPIListen    string
+	StatsPollInterval time.Duration
+
+	SubscriptionBaseURL string
+	TrustedProxies      []string
+
+	ClientBlockRuleSets  []string
+	ClientDirectRuleSets []string
+
+	ClashAPIListen      string
+	ClashAPISecret      string
+	DeviceCheckInterval time.Duration
+
+	RestartBackoffMin time.Duration
+	RestartBackoffMax time.Duration
+	RestartMaxCrashes int
+	RestartWindow     time.Duration
 }
 
 func LoadConfigFromEnv() Config {
@@ -38,23 +121,82 @@ func LoadConfigFromEnv() Config {
 		"127.0.0.1",
 	)
 
+	security := normalizeSecurity(os.Getenv("VLESS_SECURITY"))
+	tlsMode := normalizeTLSMode(os.Getenv("VLESS_TLS_MODE"))
+	acmeChallenge := normalizeACMEChallenge(os.Getenv("VLESS_ACME_CHALLENGE"))
+	realityHandshake := envOrDefault("VLESS_REALITY_HANDSHAKE", "www.microsoft.com:443")
+	extraInbounds, skippedInbounds := parseExtraInbounds(os.Getenv("VLESS_EXTRA_INBOUNDS"))
+
 	return Config{
 		StateDir:          stateDir,
 		Interface:         "vless",
 		ListenAddress:     envOrDefault("VLESS_LISTEN_ADDRESS", "::"),
 		ListenPort:        envInt("VLESS_LISTEN_PORT", envInt("WG_LISTEN_PORT", 443)),
 		EndpointHost:      endpoint,
+		Protocol:          normalizeProtocol(os.Getenv("VLESS_PROTOCOL")),
 		WebsocketPath:     normalizeWebsocketPath(envOrDefault("VLESS_WS_PATH", "/vpn")),
+		Transport:         normalizeTransport(os.Getenv("VLESS_TRANSPORT"), security),
+		GRPCServiceName:   envOrDefault("VLESS_GRPC_SERVICE_NAME", "vpn"),
+		Security:          security,
 		TLSServerName:     envOrDefault("VLESS_TLS_SERVER_NAME", endpoint),
 		TLSCertPath:       envOrDefault("VLESS_TLS_CERT_PATH", "/etc/vpn/tls/server.crt"),
 		TLSKeyPath:        envOrDefault("VLESS_TLS_KEY_PATH", "/etc/vpn/tls/server.key"),
+		TLSMode:           tlsMode,
+		TLSKeyType:        normalizeKeyType(os.Getenv("VLESS_TLS_KEY_TYPE")),
+		TLSCertValidity:   envDuration("VLESS_TLS_CERT_VALIDITY", 365*24*time.Hour),
+		TLSCertSANs:       splitAndTrimCSV(os.Getenv("VLESS_TLS_CERT_SANS")),
 		ClientTunName:     envOrDefault("VLESS_CLIENT_TUN_NAME", "sb-tun"),
 		ClientTunCIDR:     envOrDefault("VLESS_CLIENT_TUN_CIDR", "172.19.0.1/30"),
-		ClientInsecureTLS: envBool("VLESS_CLIENT_INSECURE_TLS", true),
+		ClientInsecureTLS: envBool("VLESS_CLIENT_INSECURE_TLS", false),
+		ClientPinCert:     envBool("VLESS_CLIENT_PIN_CERT", true),
 		SingBoxBinary:     envOrDefault("SING_BOX_BIN", "sing-box"),
+		ValidateConfig:    envBool("VLESS_VALIDATE_CONFIG", true),
 		APIBind:           envOrDefault("API_BIND", "127.0.0.1:8080"),
 		APIToken:          strings.TrimSpace(os.Getenv("API_TOKEN")),
 		AutoStart:         envBool("VLESS_AUTOSTART", envBool("WG_AUTOSTART", true)),
+
+		RealityHandshakeServer: realityHandshake,
+		RealityServerName:      envOrDefault("VLESS_REALITY_SERVER_NAME", realityHandshakeHost(realityHandshake)),
+		RealityFingerprint:     envOrDefault("VLESS_REALITY_FINGERPRINT", "chrome"),
+		RealityPrivateKey:      strings.TrimSpace(os.Getenv("VLESS_REALITY_PRIVATE_KEY")),
+		RealityShortIDs:        splitAndTrimCSV(os.Getenv("VLESS_REALITY_SHORT_IDS")),
+
+		ShadowsocksMethod:    strings.TrimSpace(os.Getenv("VLESS_SS_METHOD")),
+		ShadowsocksServerKey: strings.TrimSpace(os.Getenv("VLESS_SS_SERVER_KEY")),
+
+		ACMEDirectoryURL:  envOrDefault("VLESS_ACME_DIRECTORY", "https://acme-v02.api.letsencrypt.org/directory"),
+		ACMEEmail:         strings.TrimSpace(os.Getenv("VLESS_ACME_EMAIL")),
+		ACMEChallenge:     acmeChallenge,
+		ACMEBind:          envOrDefault("VLESS_ACME_BIND", defaultACMEBind(acmeChallenge)),
+		CertRenewBefore:   envDuration("VLESS_CERT_RENEW_BEFORE", envDuration("VLESS_ACME_RENEW_BEFORE", 30*24*time.Hour)),
+		CertAutoRotate:    envBool("VLESS_CERT_AUTO_ROTATE", true),
+		CertCheckInterval: envDuration("VLESS_CERT_CHECK_INTERVAL", 12*time.Hour),
+
+		ExtraInbounds:   extraInbounds,
+		SkippedInbounds: skippedInbounds,
+
+		StopGracePeriod:    envDuration("VLESS_STOP_GRACE_PERIOD", 5*time.Second),
+		APIShutdownTimeout: envDuration("API_SHUTDOWN_TIMEOUT", 5*time.Second),
+
+		ExpiryCheckInterval: envDuration("VLESS_EXPIRY_CHECK_INTERVAL", time.Minute),
+
+		StatsAPIListen:    strings.TrimSpace(os.Getenv("VLESS_STATS_API")),
+		StatsPollInterval: envDuration("VLESS_STATS_POLL_INTERVAL", time.Minute),
+
+		SubscriptionBaseURL: strings.TrimSpace(os.Getenv("VLESS_SUB_BASE_URL")),
+		TrustedProxies:      splitAndTrimCSV(os.Getenv("VLESS_TRUSTED_PROXIES")),
+
+		ClientBlockRuleSets:  ruleSetList(os.Getenv("VLESS_CLIENT_BLOCK_RULE_SETS")),
+		ClientDirectRuleSets: ruleSetList(os.Getenv("VLESS_CLIENT_DIRECT_RULE_SETS")),
+
+		ClashAPIListen:      optionalAddress(envOrDefault("VLESS_CLASH_API", "127.0.0.1:9090")),
+		ClashAPISecret:      strings.TrimSpace(os.Getenv("VLESS_CLASH_API_SECRET")),
+		DeviceCheckInterval: envDuration("VLESS_DEVICE_CHECK_INTERVAL", 15*time.Second),
+
+		RestartBackoffMin: envDuration("VLESS_RESTART_BACKOFF_MIN", time.Second),
+		RestartBackoffMax: envDuration("VLESS_RESTART_BACKOFF_MAX", time.Minute),
+		RestartMaxCrashes: envInt("VLESS_RESTART_MAX_CRASHES", 5),
+		RestartWindow:     envDuration("VLESS_RESTART_WINDOW", 10*time.Minute),
 	}
 }
 
@@ -77,6 +219,18 @@ func envInt(key string, fallback int) int {
 	return parsed
 }
 
+func envDuration(key string, fallback time.Duration) time.Duration {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(raw)
+	if err != nil || parsed <= 0 {
+		return fallback
+	}
+	return parsed
+}
+
 func envBool(key string, fallback bool) bool {
 	raw := strings.TrimSpace(strings.ToLower(os.Getenv(key)))
 	if raw == "" {
@@ -92,6 +246,22 @@ func envBool(key string, fallback bool) bool {
 	}
 }
 
+// optionalAddress lets an enabled-by-default listener be switched off.
+func optionalAddress(raw string) string {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "off", "false", "no", "none", "0":
+		return ""
+	default:
+		return strings.TrimSpace(raw)
+	}
+}
+
+// ruleSetList parses sing-geosite/sing-geoip rule-set names such as
+// "geosite-ru,geoip-ru"; "off" yields an empty list.
+func ruleSetList(raw string) []string {
+	return splitAndTrimCSV(optionalAddress(raw))
+}
+
 func normalizeWebsocketPath(path string) string {
 	trimmed := strings.TrimSpace(path)
 	if trimmed == "" {
@@ -103,6 +273,112 @@ func normalizeWebsocketPath(path string) string {
 	return trimmed
 }
 
+func normalizeProtocol(raw string) string {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case ProtocolTrojan:
+		return ProtocolTrojan
+	case ProtocolShadowsocks, "ss", "ss2022":
+		return ProtocolShadowsocks
+	case ProtocolHysteria2, "hy2":
+		return ProtocolHysteria2
+	default:
+		return ProtocolVLESS
+	}
+}
+
+func normalizeSecurity(raw string) string {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case SecurityReality:
+		return SecurityReality
+	default:
+		return SecurityTLS
+	}
+}
+
+func normalizeTransport(raw, security string) string {
+	switch t := strings.ToLower(strings.TrimSpace(raw)); t {
+	case TransportWS, TransportGRPC, TransportHTTPUpgrade, TransportTCP:
+		return t
+	default:
+		if security == SecurityReality {
+			return TransportTCP
+		}
+		return TransportWS
+	}
+}
+
+func normalizeKeyType(raw string) string {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case KeyTypeECDSA, "ecdsa-p256", "p256":
+		return KeyTypeECDSA
+	case KeyTypeEd25519:
+		return KeyTypeEd25519
+	default:
+		return KeyTypeRSA
+	}
+}
+
+func normalizeTLSMode(raw string) string {
+	if strings.ToLower(strings.TrimSpace(raw)) == TLSModeACME {
+		return TLSModeACME
+	}
+	return TLSModeSelfSigned
+}
+
+func normalizeACMEChallenge(raw string) string {
+	if strings.ToLower(strings.TrimSpace(raw)) == ACMEChallengeTLSALPN01 {
+		return ACMEChallengeTLSALPN01
+	}
+	return ACMEChallengeHTTP01
+}
+
+func defaultACMEBind(challenge string) string {
+	if challenge == ACMEChallengeTLSALPN01 {
+		return ":443"
+	}
+	return ":80"
+}
+
+func (c Config) protocolType() string {
+	return normalizeProtocol(c.Protocol)
+}
+
+func (c Config) transportType() string {
+	return normalizeTransport(c.Transport, c.Security)
+}
+
+func (c Config) securityType() string {
+	if c.Security == SecurityReality && protocolFor(c).supportsReality() {
+		return SecurityReality
+	}
+	return SecurityTLS
+}
+
+func (c Config) vlessFlow() string {
+	if c.protocolType() == ProtocolVLESS && c.transportType() == TransportTCP {
+		return "xtls-rprx-vision"
+	}
+	return ""
+}
+
+func (c Config) transportLabel() string {
+	switch c.protocolType() {
+	case ProtocolShadowsocks:
+		return "tcp+udp"
+	case ProtocolHysteria2:
+		return "quic+tls"
+	default:
+		return c.transportType() + "+" + c.securityType()
+	}
+}
+
+func (c Config) inboundLabel() string {
+	if c.protocolType() == ProtocolVLESS {
+		return c.transportLabel()
+	}
+	return c.protocolType() + " " + c.transportLabel()
+}
+
 func firstNonEmpty(values ...string) string {
 	for _, v := range values {
 		trimmed := strings.TrimSpace(v)
@@ -0,0 +1,130 @@
+package vpnserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const configCheckTimeout = 15 * time.Second
+
+type ConfigCheckError struct {
+	Output string
+	Err    error
+}
+
+func (e *ConfigCheckError) Error() string {
+	if e.Output == "" {
+		return fmt.Sprintf("sing-box rejected generated server config: %v", e.Err)
+	}
+	return fmt.Sprintf("sing-box rejected generated server config: %v: %s", e.Err, e.Output)
+}
+
+func (e *ConfigCheckError) Unwrap() error {
+	return e.Err
+}
+
+func (m *Manager) applyServerConfigLocked(payload []byte) error {
+	candidatePath := m.serverConfigPath + ".check"
+	if err := writeSecretFile(candidatePath, payload); err != nil {
+		return fmt.Errorf("write server config: %w", err)
+	}
+
+	if err := m.checkServerConfigLocked(candidatePath); err != nil {
+		_ = os.Remove(candidatePath)
+		return err
+	}
+
+	if err := os.Rename(candidatePath, m.serverConfigPath); err != nil {
+		_ = os.Remove(candidatePath)
+		return fmt.Errorf("write server config: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) checkServerConfigLocked(path string) error {
+	if !m.cfg.ValidateConfig {
+		return nil
+	}
+
+	binary, err := exec.LookPath(m.cfg.SingBoxBinary)
+	if err != nil {
+		if !m.checkSkipLogged {
+			m.logger.Printf("WARNING: %s not found, skipping server config validation", m.cfg.SingBoxBinary)
+			m.checkSkipLogged = true
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), configCheckTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, binary, "check", "-c", path).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) && ctx.Err() == nil {
+		return fmt.Errorf("run sing-box check: %w", err)
+	}
+	return &ConfigCheckError{
+		Output: strings.TrimSpace(string(out)),
+		Err:    err,
+	}
+}
+
+// checkSingBoxTagsLocked fails fast when an enabled API needs a build tag the
+// installed sing-box lacks; official release binaries are built without
+// with_v2ray_api, and `sing-box check` would otherwise reject every config.
+func (m *Manager) checkSingBoxTagsLocked() error {
+	required := map[string]string{}
+	if m.cfg.StatsAPIListen != "" {
+		required["with_v2ray_api"] = "VLESS_STATS_API"
+	}
+	if m.cfg.ClashAPIListen != "" {
+		required["with_clash_api"] = "VLESS_CLASH_API"
+	}
+	if len(required) == 0 {
+		return nil
+	}
+
+	binary, err := exec.LookPath(m.cfg.SingBoxBinary)
+	if err != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), configCheckTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, binary, "version").Output()
+	if err != nil {
+		return fmt.Errorf("run sing-box version: %w", err)
+	}
+
+	tags := singBoxBuildTags(string(out))
+	var missing []string
+	for tag, env := range required {
+		if !slices.Contains(tags, tag) {
+			missing = append(missing, fmt.Sprintf("%s needs a sing-box built with -tags %s", env, tag))
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("%s: %s (or disable the API)", binary, strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+func singBoxBuildTags(version string) []string {
+	for _, line := range strings.Split(version, "\n") {
+		if tags, ok := strings.CutPrefix(strings.TrimSpace(line), "Tags:"); ok {
+			return splitAndTrimCSV(tags)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package vpnserver
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestApplyServerConfig_RejectsConfigThatFailsCheck(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake sing-box is a shell script")
+	}
+
+	mgr := newTestManager(t)
+	before := readServerConfig(t, mgr)
+
+	script := filepath.Join(t.TempDir(), "sing-box")
+	body := "#!/bin/sh\necho 'FATAL[0000] decode config: inbounds[0].transport.path: invalid' \nexit 1\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatalf("write fake sing-box: %v", err)
+	}
+	mgr.cfg.SingBoxBinary = script
+	mgr.cfg.ValidateConfig = true
+
+	_, _, err := mgr.CreateClient("broken", nil)
+	var checkErr *ConfigCheckError
+	if !errors.As(err, &checkErr) {
+		t.Fatalf("got err %v, want ConfigCheckError", err)
+	}
+	if !strings.Contains(checkErr.Output, "transport.path") {
+		t.Fatalf("validator output must be preserved, got %q", checkErr.Output)
+	}
+	if got := readServerConfig(t, mgr); got != before {
+		t.Fatalf("rejected config must not replace server.json")
+	}
+	if _, err := os.Stat(mgr.serverConfigPath + ".check"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("candidate config must be cleaned up, stat err: %v", err)
+	}
+}
+
+func TestApplyServerConfig_AcceptsConfigThatPassesCheck(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake sing-box is a shell script")
+	}
+
+	mgr := newTestManager(t)
+	script := filepath.Join(t.TempDir(), "sing-box")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n[ \"$1\" = check ] || exit 2\nexit 0\n"), 0o755); err != nil {
+		t.Fatalf("write fake sing-box: %v", err)
+	}
+	mgr.cfg.SingBoxBinary = script
+	mgr.cfg.ValidateConfig = true
+
+	c, _, err := mgr.CreateClient("valid", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	if !strings.Contains(readServerConfig(t, mgr), c.UUID) {
+		t.Fatalf("validated config must be applied")
+	}
+}
+
+func TestRejectedConfig_LeavesClientsStateUnchanged(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake sing-box is a shell script")
+	}
+
+	mgr := newTestManager(t)
+	alice, _, err := mgr.CreateClient("alice", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	before, err := os.ReadFile(mgr.statePath)
+	if err != nil {
+		t.Fatalf("read clients state: %v", err)
+	}
+
+	script := filepath.Join(t.TempDir(), "sing-box")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho 'FATAL[0000] rejected'\nexit 1\n"), 0o755); err != nil {
+		t.Fatalf("write fake sing-box: %v", err)
+	}
+	mgr.cfg.SingBoxBinary = script
+	mgr.cfg.ValidateConfig = true
+
+	var checkErr *ConfigCheckError
+	if _, _, err := mgr.CreateClient("bob", nil); !errors.As(err, &checkErr) {
+		t.Fatalf("create: got err %v, want ConfigCheckError", err)
+	}
+	if _, err := mgr.SetClientDisabled(alice.ID, true); !errors.As(err, &checkErr) {
+		t.Fatalf("disable: got err %v, want ConfigCheckError", err)
+	}
+	if _, _, err := mgr.RotateClientCredentials(alice.ID); !errors.As(err, &checkErr) {
+		t.Fatalf("rotate: got err %v, want ConfigCheckError", err)
+	}
+
+	after, err := os.ReadFile(mgr.statePath)
+	if err != nil {
+		t.Fatalf("read clients state: %v", err)
+	}
+	if string(after) != string(before) {
+		t.Fatalf("rejected changes must not be persisted:\nbefore: %s\nafter: %s", before, after)
+	}
+}
+
+func TestInitState_RequiresV2RayAPIBuildTag(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake sing-box is a shell script")
+	}
+
+	stateDir := t.TempDir()
+	script := filepath.Join(t.TempDir(), "sing-box")
+	body := "#!/bin/sh\necho 'sing-box version 1.12.21'\necho\necho 'Tags: with_gvisor,with_quic,with_utls,with_clash_api'\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatalf("write fake sing-box: %v", err)
+	}
+	cfg := Config{
+		StateDir:       stateDir,
+		ListenPort:     8443,
+		TLSCertPath:    filepath.Join(stateDir, "tls", "server.crt"),
+		TLSKeyPath:     filepath.Join(stateDir, "tls", "server.key"),
+		SingBoxBinary:  script,
+		ClashAPIListen: "127.0.0.1:9090",
+		StatsAPIListen: "127.0.0.1:10085",
+	}
+	err := NewManager(cfg, log.New(io.Discard, "", 0)).InitState()
+	if err == nil || !strings.Contains(err.Error(), "VLESS_STATS_API needs a sing-box built with -tags with_v2ray_api") {
+		t.Fatalf("missing with_v2ray_api must fail fast, got %v", err)
+	}
+	if strings.Contains(err.Error(), "with_clash_api") {
+		t.Fatalf("present tags must not be reported: %v", err)
+	}
+}
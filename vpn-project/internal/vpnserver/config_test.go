@@ -9,3 +9,12 @@ func TestNormalizeWebsocketPath(t *testing.T) {
 		t.Fatalf("got %q, want %q", got, want)
 	}
 }
+
+func TestLoadConfigFromEnv_ClientRuleSetsDefaultToEmpty(t *testing.T) {
+	t.Setenv("VLESS_CLIENT_BLOCK_RULE_SETS", "")
+	t.Setenv("VLESS_CLIENT_DIRECT_RULE_SETS", "")
+	cfg := LoadConfigFromEnv()
+	if len(cfg.ClientBlockRuleSets) != 0 || len(cfg.ClientDirectRuleSets) != 0 {
+		t.Fatalf("client profiles must route like the share links by default: %v %v", cfg.ClientBlockRuleSets, cfg.ClientDirectRuleSets)
+	}
+}
@@ -0,0 +1,359 @@
+package vpnserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrClashAPIDisabled = errors.New("connection tracking requires VLESS_CLASH_API")
+
+type Connection struct {
+	ID            string    `json:"id"`
+	ClientID      string    `json:"client_id,omitempty"`
+	User          string    `json:"user,omitempty"`
+	Inbound       string    `json:"inbound"`
+	Network       string    `json:"network"`
+	SourceIP      string    `json:"source_ip"`
+	SourcePort    string    `json:"source_port"`
+	Destination   string    `json:"destination"`
+	UploadBytes   int64     `json:"upload_bytes"`
+	DownloadBytes int64     `json:"download_bytes"`
+	Start         time.Time `json:"start"`
+}
+
+type ClientSession struct {
+	ClientID      string    `json:"client_id"`
+	Name          string    `json:"name"`
+	Connections   int       `json:"connections"`
+	SourceIPs     []string  `json:"source_ips"`
+	Since         time.Time `json:"since"`
+	UploadBytes   int64     `json:"upload_bytes"`
+	DownloadBytes int64     `json:"download_bytes"`
+}
+
+type ConnectionsResponse struct {
+	Running     bool            `json:"running"`
+	Sessions    []ClientSession `json:"sessions"`
+	Connections []Connection    `json:"connections"`
+}
+
+type clashAPIMaterial struct {
+	Secret string `json:"secret"`
+}
+
+// ensureClashAPIMaterialLocked gives the Clash API a secret: it can close any
+// connection, so it is never served unauthenticated. Without
+// VLESS_CLASH_API_SECRET one is generated once and kept in the state dir.
+func (m *Manager) ensureClashAPIMaterialLocked() error {
+	if m.cfg.ClashAPIListen == "" || m.cfg.ClashAPISecret != "" {
+		return nil
+	}
+
+	path := filepath.Join(m.cfg.StateDir, "clash_api.json")
+	var stored clashAPIMaterial
+	raw, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(raw, &stored); err != nil {
+			return fmt.Errorf("parse clash api state: %w", err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("read clash api state: %w", err)
+	}
+
+	if stored.Secret == "" {
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			return fmt.Errorf("generate clash api secret: %w", err)
+		}
+		stored.Secret = hex.EncodeToString(b)
+		payload, err := marshalPretty(stored)
+		if err != nil {
+			return fmt.Errorf("serialize clash api state: %w", err)
+		}
+		if err := writeSecretFile(path, payload); err != nil {
+			return fmt.Errorf("write clash api state: %w", err)
+		}
+		m.logger.Printf("generated Clash API secret at %s", path)
+	}
+
+	m.cfg.ClashAPISecret = stored.Secret
+	return nil
+}
+
+func clashAPIOptions(cfg Config) map[string]any {
+	options := map[string]any{
+		"external_controller": cfg.ClashAPIListen,
+	}
+	if cfg.ClashAPISecret != "" {
+		options["secret"] = cfg.ClashAPISecret
+	}
+	return options
+}
+
+// clashUserRules adds one no-op rule per user so the Clash API reports which
+// user a connection belongs to: its "rule" field reads "auth_user=NAME => ...".
+func clashUserRules(clients []Client) []any {
+	rules := make([]any, 0, len(clients))
+	for _, c := range clients {
+		rules = append(rules, map[string]any{
+			"auth_user": []string{c.serverUserName()},
+			"action":    "route",
+			"outbound":  "direct",
+		})
+	}
+	return rules
+}
+
+func clashRuleUser(rule string) (string, bool) {
+	if i := strings.LastIndex(rule, " => "); i >= 0 {
+		rule = rule[:i]
+	}
+	return strings.CutPrefix(rule, "auth_user=")
+}
+
+type clashClient struct {
+	addr   string
+	secret string
+	http   *http.Client
+}
+
+type clashConnection struct {
+	ID       string `json:"id"`
+	Metadata struct {
+		Network         string `json:"network"`
+		Type            string `json:"type"`
+		SourceIP        string `json:"sourceIP"`
+		SourcePort      string `json:"sourcePort"`
+		DestinationIP   string `json:"destinationIP"`
+		DestinationPort string `json:"destinationPort"`
+		Host            string `json:"host"`
+	} `json:"metadata"`
+	Upload   int64     `json:"upload"`
+	Download int64     `json:"download"`
+	Start    time.Time `json:"start"`
+	Rule     string    `json:"rule"`
+}
+
+func newClashClient(addr, secret string) *clashClient {
+	return &clashClient{
+		addr:   addr,
+		secret: secret,
+		http:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *clashClient) do(ctx context.Context, method, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+c.addr+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.secret != "" {
+		req.Header.Set("Authorization", "Bearer "+c.secret)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		resp.Body.Close()
+		return nil, fmt.Errorf("clash api %s %s: status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+func (c *clashClient) connections(ctx context.Context) ([]clashConnection, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/connections")
+	if err != nil {
+		return nil, fmt.Errorf("list connections: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var payload struct {
+		Connections []clashConnection `json:"connections"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return nil, fmt.Errorf("parse connections: %w", err)
+	}
+	return payload.Connections, nil
+}
+
+func (c *clashClient) closeConnection(ctx context.Context, id string) error {
+	resp, err := c.do(ctx, http.MethodDelete, "/connections/"+url.PathEscape(id))
+	if err != nil {
+		return fmt.Errorf("close connection %s: %w", id, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// clashLocked returns a Clash API client, or nil when sing-box is not running.
+func (m *Manager) clashLocked() (*clashClient, error) {
+	if m.cfg.ClashAPIListen == "" {
+		return nil, ErrClashAPIDisabled
+	}
+	if !m.interfaceRunningLocked() {
+		return nil, nil
+	}
+	if m.clash == nil || m.clash.addr != m.cfg.ClashAPIListen || m.clash.secret != m.cfg.ClashAPISecret {
+		m.clash = newClashClient(m.cfg.ClashAPIListen, m.cfg.ClashAPISecret)
+	}
+	return m.clash, nil
+}
+
+// liveConnections returns the clients and their open connections; the Clash
+// client is nil when sing-box is not running.
+func (m *Manager) liveConnections(ctx context.Context) (map[string]Client, []Connection, *clashClient, error) {
+	m.mu.Lock()
+	clients, err := m.loadClientsLocked()
+	if err != nil {
+		m.mu.Unlock()
+		return nil, nil, nil, err
+	}
+	clash, err := m.clashLocked()
+	m.mu.Unlock()
+	if err != nil || clash == nil {
+		return clients, nil, nil, err
+	}
+
+	raw, err := clash.connections(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	list := make([]Client, 0, len(clients))
+	for _, c := range clients {
+		list = append(list, c)
+	}
+	byName := map[string]string{}
+	for id, name := range serverUserNames(list) {
+		byName[name] = id
+	}
+
+	conns := make([]Connection, 0, len(raw))
+	for _, rc := range raw {
+		conn := clashToConnection(rc)
+		if user, ok := clashRuleUser(rc.Rule); ok {
+			conn.User = user
+			conn.ClientID = byName[user]
+		}
+		conns = append(conns, conn)
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].Start.Before(conns[j].Start)
+	})
+	return clients, conns, clash, nil
+}
+
+// ListConnections reports open proxy connections grouped by client. An empty
+// clientID lists every client.
+func (m *Manager) ListConnections(ctx context.Context, clientID string) (ConnectionsResponse, error) {
+	clients, conns, clash, err := m.liveConnections(ctx)
+	if err != nil {
+		return ConnectionsResponse{}, err
+	}
+	if _, ok := clients[clientID]; clientID != "" && !ok {
+		return ConnectionsResponse{}, os.ErrNotExist
+	}
+
+	resp := ConnectionsResponse{Running: clash != nil, Sessions: []ClientSession{}, Connections: []Connection{}}
+	sessions := map[string]*ClientSession{}
+	for _, conn := range conns {
+		if clientID != "" && conn.ClientID != clientID {
+			continue
+		}
+		resp.Connections = append(resp.Connections, conn)
+
+		if conn.ClientID == "" {
+			continue
+		}
+		s := sessions[conn.ClientID]
+		if s == nil {
+			s = &ClientSession{ClientID: conn.ClientID, Name: clients[conn.ClientID].Name, Since: conn.Start}
+			sessions[conn.ClientID] = s
+		}
+		s.Connections++
+		s.UploadBytes += conn.UploadBytes
+		s.DownloadBytes += conn.DownloadBytes
+		if !slices.Contains(s.SourceIPs, conn.SourceIP) {
+			s.SourceIPs = append(s.SourceIPs, conn.SourceIP)
+		}
+	}
+
+	for _, s := range sessions {
+		sort.Strings(s.SourceIPs)
+		resp.Sessions = append(resp.Sessions, *s)
+	}
+	sort.Slice(resp.Sessions, func(i, j int) bool {
+		return resp.Sessions[i].ClientID < resp.Sessions[j].ClientID
+	})
+	return resp, nil
+}
+
+func clashToConnection(rc clashConnection) Connection {
+	md := rc.Metadata
+	inbound := md.Type
+	if _, tag, ok := strings.Cut(md.Type, "/"); ok {
+		inbound = tag
+	}
+	destination := firstNonEmpty(md.Host, md.DestinationIP)
+	if md.DestinationPort != "" {
+		destination = net.JoinHostPort(destination, md.DestinationPort)
+	}
+	return Connection{
+		ID:            rc.ID,
+		Inbound:       inbound,
+		Network:       md.Network,
+		SourceIP:      md.SourceIP,
+		SourcePort:    md.SourcePort,
+		Destination:   destination,
+		UploadBytes:   rc.Upload,
+		DownloadBytes: rc.Download,
+		Start:         rc.Start,
+	}
+}
+
+// KickClient closes every open connection of a client without reloading
+// sing-box.
+func (m *Manager) KickClient(ctx context.Context, clientID string) (int, error) {
+	clients, conns, clash, err := m.liveConnections(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := clients[clientID]; !ok {
+		return 0, os.ErrNotExist
+	}
+	if clash == nil {
+		return 0, nil
+	}
+
+	var errs []error
+	closed := 0
+	for _, conn := range conns {
+		if conn.ClientID != clientID {
+			continue
+		}
+		if err := clash.closeConnection(ctx, conn.ID); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		closed++
+	}
+	m.logger.Printf("client %s kicked: closed %d connections", clientID, closed)
+	return closed, errors.Join(errs...)
+}
@@ -0,0 +1,230 @@
+package vpnserver
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeClashAPI serves /connections in the shape sing-box's Clash API uses.
+type fakeClashAPI struct {
+	srv    *httptest.Server
+	secret string
+
+	mu     sync.Mutex
+	conns  []map[string]any
+	closed []string
+}
+
+func newFakeClashAPI(t *testing.T, secret string, conns ...map[string]any) *fakeClashAPI {
+	t.Helper()
+	f := &fakeClashAPI{secret: secret, conns: conns}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeClashAPI) addr() string {
+	return strings.TrimPrefix(f.srv.URL, "http://")
+}
+
+func (f *fakeClashAPI) handle(w http.ResponseWriter, r *http.Request) {
+	if f.secret != "" && r.Header.Get("Authorization") != "Bearer "+f.secret {
+		http.Error(w, `{"message":"Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/connections":
+		writeJSON(w, http.StatusOK, map[string]any{"connections": f.conns})
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/connections/"):
+		id := strings.TrimPrefix(r.URL.Path, "/connections/")
+		f.closed = append(f.closed, id)
+		kept := f.conns[:0]
+		for _, c := range f.conns {
+			if c["id"] != id {
+				kept = append(kept, c)
+			}
+		}
+		f.conns = kept
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func clashConn(id, user, sourceIP, start string) map[string]any {
+	return map[string]any{
+		"id": id,
+		"metadata": map[string]any{
+			"network":         "tcp",
+			"type":            "vless/vless-in",
+			"sourceIP":        sourceIP,
+			"sourcePort":      "50000",
+			"destinationIP":   "",
+			"destinationPort": "443",
+			"host":            "example.com",
+		},
+		"upload":   10,
+		"download": 20,
+		"start":    start,
+		"chains":   []string{"direct"},
+		"rule":     "auth_user=" + user + " => route(direct)",
+	}
+}
+
+func TestListConnections_GroupsByClient(t *testing.T) {
+	mgr := newTestManager(t)
+	alice, _, err := mgr.CreateClient("Alice MacBook", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	clash := newFakeClashAPI(t, "s3cret",
+		clashConn("c1", "Alice MacBook", "203.0.113.5", "2026-05-01T10:00:00Z"),
+		clashConn("c2", "Alice MacBook", "198.51.100.7", "2026-05-01T09:00:00Z"),
+		clashConn("c3", "default-client", "192.0.2.1", "2026-05-01T11:00:00Z"),
+	)
+	mgr.cfg.ClashAPIListen = clash.addr()
+	mgr.cfg.ClashAPISecret = "s3cret"
+	startFakeSingBox(t, mgr)
+
+	handler := NewHTTPHandler(mgr, log.New(io.Discard, "", 0))
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/clients/"+alice.ID+"/connections", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp ConnectionsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Connections) != 2 || resp.Connections[0].ID != "c2" || resp.Connections[0].Destination != "example.com:443" {
+		t.Fatalf("unexpected connections: %#v", resp.Connections)
+	}
+	if len(resp.Sessions) != 1 {
+		t.Fatalf("expected one session, got %#v", resp.Sessions)
+	}
+	s := resp.Sessions[0]
+	if s.ClientID != alice.ID || s.Connections != 2 || strings.Join(s.SourceIPs, ",") != "198.51.100.7,203.0.113.5" ||
+		s.Since.Format("15:04") != "09:00" || s.DownloadBytes != 40 {
+		t.Fatalf("unexpected session: %#v", s)
+	}
+
+	all, err := mgr.ListConnections(t.Context(), "")
+	if err != nil {
+		t.Fatalf("list connections: %v", err)
+	}
+	if len(all.Connections) != 3 || len(all.Sessions) != 2 {
+		t.Fatalf("unexpected listing: %#v", all)
+	}
+}
+
+func TestConnectionsEndpoint_RequiresClashAPI(t *testing.T) {
+	mgr := newTestManager(t)
+	handler := NewHTTPHandler(mgr, log.New(io.Discard, "", 0))
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/connections", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestBuildServerConfig_ClashAPITagsConnectionsWithUser(t *testing.T) {
+	cfg := newTestManager(t).cfg
+	cfg.ClashAPIListen = "127.0.0.1:9090"
+	raw, err := json.Marshal(buildServerConfigMap(cfg, []Client{{ID: "alice", Name: "Alice", UUID: "11111111-1111-1111-1111-111111111111"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := string(raw)
+	if !strings.Contains(config, `"clash_api":{"external_controller":"127.0.0.1:9090"}`) {
+		t.Fatalf("clash api must be enabled: %s", config)
+	}
+	if !strings.Contains(config, `"auth_user":["Alice"]`) {
+		t.Fatalf("route must carry a rule per user: %s", config)
+	}
+
+	if user, ok := clashRuleUser("auth_user=Alice => route(direct)"); !ok || user != "Alice" {
+		t.Fatalf("unexpected rule user %q", user)
+	}
+	if _, ok := clashRuleUser("final"); ok {
+		t.Fatalf("unmatched connections must not be attributed to a user")
+	}
+}
+
+func TestClashAPISecret_GeneratedAndPersisted(t *testing.T) {
+	mgr := newTestManager(t)
+	mgr.cfg.ClashAPIListen = "127.0.0.1:9090"
+	if err := mgr.ensureClashAPIMaterialLocked(); err != nil {
+		t.Fatalf("ensure clash api secret: %v", err)
+	}
+	secret := mgr.cfg.ClashAPISecret
+	if len(secret) < 32 {
+		t.Fatalf("clash api must get a generated secret, got %q", secret)
+	}
+	if options := clashAPIOptions(mgr.cfg); options["secret"] != secret {
+		t.Fatalf("server config must carry the secret: %#v", options)
+	}
+
+	mgr.cfg.ClashAPISecret = ""
+	if err := mgr.ensureClashAPIMaterialLocked(); err != nil {
+		t.Fatalf("reload clash api secret: %v", err)
+	}
+	if mgr.cfg.ClashAPISecret != secret {
+		t.Fatalf("clash api secret must survive restarts")
+	}
+}
+
+func TestKickEndpoint_ClosesOnlyThatClientsConnections(t *testing.T) {
+	mgr := newTestManager(t)
+	alice, _, err := mgr.CreateClient("alice", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	clash := newFakeClashAPI(t, "",
+		clashConn("a1", "alice", "203.0.113.5", "2026-05-01T10:00:00Z"),
+		clashConn("a2", "alice", "198.51.100.7", "2026-05-01T10:05:00Z"),
+		clashConn("d1", "default-client", "192.0.2.1", "2026-05-01T11:00:00Z"),
+	)
+	mgr.cfg.ClashAPIListen = clash.addr()
+	startFakeSingBox(t, mgr)
+
+	mgr.mu.Lock()
+	pid := mgr.serverCmd.Process.Pid
+	mgr.mu.Unlock()
+
+	handler := NewHTTPHandler(mgr, log.New(io.Discard, "", 0))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/clients/"+alice.ID+"/kick", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"closed_connections":2`) {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	clash.mu.Lock()
+	closed := strings.Join(clash.closed, ",")
+	remaining := len(clash.conns)
+	clash.mu.Unlock()
+	if closed != "a1,a2" || remaining != 1 {
+		t.Fatalf("unexpected closed connections %q (remaining %d)", closed, remaining)
+	}
+
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	if mgr.serverCmd == nil || mgr.serverCmd.Process.Pid != pid {
+		t.Fatalf("kick must not restart sing-box")
+	}
+}
@@ -0,0 +1,110 @@
+package vpnserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+const maxDeviceViolations = 20
+
+type DeviceViolation struct {
+	At                time.Time `json:"at"`
+	Limit             int       `json:"limit"`
+	SourceIPs         []string  `json:"source_ips"`
+	RejectedIPs       []string  `json:"rejected_ips"`
+	ClosedConnections int       `json:"closed_connections"`
+}
+
+// EnforceDeviceLimits closes connections from the newest source IPs of every
+// client that is connected from more than MaxDevices addresses at once.
+func (m *Manager) EnforceDeviceLimits(ctx context.Context) (int, error) {
+	clients, conns, clash, err := m.liveConnections(ctx)
+	if err != nil || clash == nil {
+		return 0, err
+	}
+
+	// conns are ordered by start time, so the first addresses seen for a
+	// client are the devices that connected first and get to stay.
+	byClient := map[string][]Connection{}
+	for _, conn := range conns {
+		if c, ok := clients[conn.ClientID]; ok && c.MaxDevices > 0 {
+			byClient[conn.ClientID] = append(byClient[conn.ClientID], conn)
+		}
+	}
+
+	violations := map[string]DeviceViolation{}
+	var errs []error
+	closed := 0
+	for id, list := range byClient {
+		limit := clients[id].MaxDevices
+		var allowed, rejected []string
+		for _, conn := range list {
+			if slices.Contains(allowed, conn.SourceIP) || slices.Contains(rejected, conn.SourceIP) {
+				continue
+			}
+			if len(allowed) < limit {
+				allowed = append(allowed, conn.SourceIP)
+			} else {
+				rejected = append(rejected, conn.SourceIP)
+			}
+		}
+		if len(rejected) == 0 {
+			continue
+		}
+
+		v := DeviceViolation{
+			At:          time.Now().UTC(),
+			Limit:       limit,
+			SourceIPs:   append(append([]string{}, allowed...), rejected...),
+			RejectedIPs: rejected,
+		}
+		for _, conn := range list {
+			if !slices.Contains(rejected, conn.SourceIP) {
+				continue
+			}
+			if err := clash.closeConnection(ctx, conn.ID); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			v.ClosedConnections++
+		}
+		closed += v.ClosedConnections
+		violations[id] = v
+	}
+
+	if len(violations) > 0 {
+		if err := m.recordDeviceViolations(violations); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return closed, errors.Join(errs...)
+}
+
+func (m *Manager) recordDeviceViolations(violations map[string]DeviceViolation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clients, err := m.loadClientsLocked()
+	if err != nil {
+		return err
+	}
+	for id, v := range violations {
+		c, ok := clients[id]
+		if !ok {
+			continue
+		}
+		c.DeviceViolations = append(c.DeviceViolations, v)
+		if n := len(c.DeviceViolations); n > maxDeviceViolations {
+			c.DeviceViolations = c.DeviceViolations[n-maxDeviceViolations:]
+		}
+		clients[id] = c
+		m.logger.Printf("client %s exceeded its device limit of %d: closed %d connections from %v", id, v.Limit, v.ClosedConnections, v.RejectedIPs)
+	}
+	if err := m.saveClientsLocked(clients); err != nil {
+		return fmt.Errorf("record device violations: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package vpnserver
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEnforceDeviceLimits_ClosesNewestDevices(t *testing.T) {
+	mgr := newTestManager(t)
+	alice, _, err := mgr.CreateClient("alice", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	limit := 1
+	if _, err := mgr.UpdateClient(alice.ID, ClientUpdate{MaxDevices: &limit}); err != nil {
+		t.Fatalf("set device limit: %v", err)
+	}
+
+	clash := newFakeClashAPI(t, "",
+		clashConn("home-1", "alice", "203.0.113.5", "2026-05-01T09:00:00Z"),
+		clashConn("friend-1", "alice", "198.51.100.7", "2026-05-01T10:00:00Z"),
+		clashConn("home-2", "alice", "203.0.113.5", "2026-05-01T10:30:00Z"),
+		clashConn("friend-2", "alice", "198.51.100.7", "2026-05-01T11:00:00Z"),
+		clashConn("other-1", "default-client", "192.0.2.1", "2026-05-01T11:00:00Z"),
+		clashConn("other-2", "default-client", "192.0.2.2", "2026-05-01T11:00:00Z"),
+	)
+	mgr.cfg.ClashAPIListen = clash.addr()
+	startFakeSingBox(t, mgr)
+
+	closed, err := mgr.EnforceDeviceLimits(t.Context())
+	if err != nil {
+		t.Fatalf("enforce device limits: %v", err)
+	}
+	clash.mu.Lock()
+	closedIDs := slices.Clone(clash.closed)
+	clash.mu.Unlock()
+	slices.Sort(closedIDs)
+	if closed != 2 || !slices.Equal(closedIDs, []string{"friend-1", "friend-2"}) {
+		t.Fatalf("expected the newest device's connections to be closed, got %d %v", closed, closedIDs)
+	}
+
+	c, err := mgr.GetClient(alice.ID)
+	if err != nil {
+		t.Fatalf("get client: %v", err)
+	}
+	if len(c.DeviceViolations) != 1 {
+		t.Fatalf("expected a recorded violation, got %#v", c.DeviceViolations)
+	}
+	v := c.DeviceViolations[0]
+	if v.Limit != 1 || !slices.Equal(v.RejectedIPs, []string{"198.51.100.7"}) || v.ClosedConnections != 2 {
+		t.Fatalf("unexpected violation: %#v", v)
+	}
+
+	closed, err = mgr.EnforceDeviceLimits(t.Context())
+	if err != nil || closed != 0 {
+		t.Fatalf("clients within their limit must be left alone: closed=%d err=%v", closed, err)
+	}
+}
@@ -11,8 +11,10 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -24,23 +26,31 @@ func NewHTTPHandler(mgr *Manager, logger *log.Logger) http.Handler {
 		mgr:      mgr,
 		logger:   logger,
 		apiToken: strings.TrimSpace(mgr.cfg.APIToken),
+		subBase:  mgr.cfg.SubscriptionBaseURL,
 	}
+	// InitState already refused invalid entries.
+	api.trustedProxies, _ = mgr.cfg.trustedProxyPrefixes()
 	return api.routes()
 }
 
 type apiServer struct {
-	mgr      *Manager
-	logger   *log.Logger
-	apiToken string
+	mgr            *Manager
+	logger         *log.Logger
+	apiToken       string
+	subBase        string
+	trustedProxies []netip.Prefix
 }
 
 func (a *apiServer) routes() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/status", a.handleStatus)
 	mux.HandleFunc("/clients", a.handleClients)
-	mux.HandleFunc("/clients/", a.handleClientConfig)
+	mux.HandleFunc("/clients/", a.handleClientRoutes)
 	mux.HandleFunc("/start", a.handleStart)
 	mux.HandleFunc("/stop", a.handleStop)
+	mux.HandleFunc("/certificate/regenerate", a.handleRegenerateCertificate)
+	mux.HandleFunc("/connections", a.handleConnections)
+	mux.HandleFunc(subscriptionPathPrefix, a.handleSubscription)
 	return accessLogMiddleware(a.logger, apiAuthMiddleware(a.apiToken, mux))
 }
 
@@ -59,13 +69,43 @@ func (a *apiServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *apiServer) handleClients(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		methodNotAllowed(w, http.MethodPost)
+	switch r.Method {
+	case http.MethodGet:
+		a.handleListClients(w, r)
+	case http.MethodPost:
+		a.handleCreateClient(w, r)
+	default:
+		methodNotAllowed(w, "GET, POST")
+	}
+}
+
+func (a *apiServer) handleListClients(w http.ResponseWriter, r *http.Request) {
+	query, err := parseClientListQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
+	clients, err := a.mgr.ListClients()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	page, total := query.apply(clients)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"clients": page,
+		"total":   total,
+		"offset":  query.Offset,
+		"limit":   query.Limit,
+	})
+}
+
+func (a *apiServer) handleCreateClient(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Name string `json:"name"`
+		Name      string     `json:"name"`
+		ExpiresAt *time.Time `json:"expires_at"`
+		TTL       string     `json:"ttl"`
 	}
 
 	if r.Body != nil {
@@ -82,46 +122,53 @@ func (a *apiServer) handleClients(w http.ResponseWriter, r *http.Request) {
 		req.Name = fmt.Sprintf("client-%d", time.Now().Unix())
 	}
 
-	c, config, err := a.mgr.CreateClient(req.Name)
+	expiresAt, err := resolveClientExpiry(req.ExpiresAt, req.TTL, time.Now().UTC())
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err)
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	vlessURI := a.mgr.ClientShareURI(c)
-	qrPayload := strings.TrimSpace(vlessURI)
-	if qrPayload == "" {
-		qrPayload = config
+	c, profile, err := a.mgr.CreateClient(req.Name, expiresAt)
+	if err != nil {
+		writeManagerError(w, err)
+		return
 	}
 
-	qrB64, err := configToQRBase64(qrPayload)
+	resp, err := a.clientConfigResponse(r, c, profile)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
-
-	resp := map[string]any{
-		"id":          c.ID,
-		"name":        c.Name,
-		"address":     c.Address,
-		"created_at":  c.CreatedAt,
-		"config":      config,
-		"vless_uri":   vlessURI,
-		"config_path": c.ConfigPath,
-		"qr_base64":   qrB64,
-	}
+	resp["config_path"] = c.ConfigPath
 	writeJSON(w, http.StatusCreated, resp)
 }
 
-func (a *apiServer) handleClientConfig(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		methodNotAllowed(w, http.MethodGet)
-		return
+func resolveClientExpiry(expiresAt *time.Time, ttl string, now time.Time) (*time.Time, error) {
+	ttl = strings.TrimSpace(ttl)
+	if expiresAt != nil && ttl != "" {
+		return nil, fmt.Errorf("expires_at and ttl are mutually exclusive")
+	}
+	if ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ttl: %w", err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("ttl must be positive")
+		}
+		exp := now.Add(d)
+		return &exp, nil
+	}
+	if expiresAt != nil && !expiresAt.After(now) {
+		return nil, fmt.Errorf("expires_at must be in the future")
 	}
+	return expiresAt, nil
+}
 
+func (a *apiServer) handleClientRoutes(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/clients/")
 	parts := strings.Split(path, "/")
-	if len(parts) != 2 || parts[1] != "config" {
+	if len(parts) > 2 {
 		http.NotFound(w, r)
 		return
 	}
@@ -137,47 +184,394 @@ func (a *apiServer) handleClientConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c, config, err := a.mgr.GetClientConfig(clientID)
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	switch action {
+	case "":
+		a.handleClient(w, r, clientID)
+	case "config":
+		a.handleClientConfig(w, r, clientID)
+	case "disable":
+		a.handleClientSetDisabled(w, r, clientID, true)
+	case "enable":
+		a.handleClientSetDisabled(w, r, clientID, false)
+	case "rotate":
+		a.handleClientRotate(w, r, clientID)
+	case "connections":
+		a.handleClientConnections(w, r, clientID)
+	case "kick":
+		a.handleClientKick(w, r, clientID)
+	case "subscription":
+		a.handleClientSubscription(w, r, clientID)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type clientResponse struct {
+	Client
+	Traffic    ClientTraffic `json:"traffic"`
+	QuotaUsage *QuotaStatus  `json:"quota_usage,omitempty"`
+}
+
+func (a *apiServer) handleClient(w http.ResponseWriter, r *http.Request, clientID string) {
+	switch r.Method {
+	case http.MethodGet:
+		c, err := a.mgr.GetClient(clientID)
+		if err != nil {
+			writeClientError(w, clientID, err)
+			return
+		}
+		traffic, err := a.mgr.GetClientTraffic(clientID)
+		if err != nil {
+			writeManagerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, clientResponse{
+			Client:     c,
+			Traffic:    traffic,
+			QuotaUsage: clientQuotaStatus(c, traffic),
+		})
+	case http.MethodPatch:
+		a.handleUpdateClient(w, r, clientID)
+	case http.MethodDelete:
+		c, err := a.mgr.DeleteClient(clientID)
+		if err != nil {
+			writeClientError(w, clientID, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{
+			"status": "deleted",
+			"id":     c.ID,
+		})
+	default:
+		methodNotAllowed(w, "GET, PATCH, DELETE")
+	}
+}
+
+func (a *apiServer) handleUpdateClient(w http.ResponseWriter, r *http.Request, clientID string) {
+	var upd ClientUpdate
+	if r.Body != nil {
+		defer r.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if strings.TrimSpace(string(body)) != "" {
+			if err := json.Unmarshal(body, &upd); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+				return
+			}
+		}
+	}
+
+	c, err := a.mgr.UpdateClient(clientID, upd)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			writeError(w, http.StatusNotFound, fmt.Errorf("client %s not found", clientID))
+		writeClientError(w, clientID, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (a *apiServer) handleClientSetDisabled(w http.ResponseWriter, r *http.Request, clientID string, disabled bool) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	c, err := a.mgr.SetClientDisabled(clientID, disabled)
+	if err != nil {
+		writeClientError(w, clientID, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (a *apiServer) handleClientConfig(w http.ResponseWriter, r *http.Request, clientID string) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	format, err := parseClientFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	c, profile, err := a.mgr.GetClientConfigFormat(clientID, format)
+	if err != nil {
+		if errors.Is(err, errXrayUnsupported) {
+			writeError(w, http.StatusUnprocessableEntity, err)
 			return
 		}
+		writeClientError(w, clientID, err)
+		return
+	}
+
+	resp, err := a.clientConfigResponse(r, c, profile)
+	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	resp["format"] = format
+	writeJSON(w, http.StatusOK, resp)
+}
 
-	vlessURI := a.mgr.ClientShareURI(c)
-	qrPayload := strings.TrimSpace(vlessURI)
-	if qrPayload == "" {
-		qrPayload = config
+func (a *apiServer) handleClientRotate(w http.ResponseWriter, r *http.Request, clientID string) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
 	}
 
-	qrB64, err := configToQRBase64(qrPayload)
+	c, profile, err := a.mgr.RotateClientCredentials(clientID)
+	if err != nil {
+		writeClientError(w, clientID, err)
+		return
+	}
+
+	resp, err := a.clientConfigResponse(r, c, profile)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	writeJSON(w, http.StatusOK, resp)
+}
 
-	resp := map[string]any{
+func (a *apiServer) clientConfigResponse(r *http.Request, c Client, profile ClientProfile) (map[string]any, error) {
+	shareURI := profile.ShareURI
+	qrPayload := strings.TrimSpace(shareURI)
+	if qrPayload == "" {
+		qrPayload = profile.Config
+	}
+
+	qrB64, err := configToQRBase64(qrPayload)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
 		"id":         c.ID,
 		"name":       c.Name,
 		"address":    c.Address,
 		"created_at": c.CreatedAt,
-		"config":     config,
-		"vless_uri":  vlessURI,
+		"expires_at": c.ExpiresAt,
+		"config":     profile.Config,
+		"share_uri":  shareURI,
+		"share_uris": profile.ShareURIs,
 		"qr_base64":  qrB64,
+
+		"subscription_url": a.subscriptionURL(r, c),
+		// Deprecated: vless_uri also carries trojan://, ss:// and
+		// hysteria2:// links; use share_uri.
+		"vless_uri": shareURI,
+	}, nil
+}
+
+// subscriptionURL uses VLESS_SUB_BASE_URL when set and otherwise the address
+// the caller reached the API on, so apps always get an absolute link.
+func (a *apiServer) subscriptionURL(r *http.Request, c Client) string {
+	base := a.subBase
+	if base == "" {
+		base = requestBaseURL(r, a.fromTrustedProxy(r))
+	}
+	return subscriptionURL(base, c.SubscriptionToken)
+}
+
+// fromTrustedProxy reports whether r came from a VLESS_TRUSTED_PROXIES
+// address, the only peers whose X-Forwarded-* headers are believed.
+func (a *apiServer) fromTrustedProxy(r *http.Request) bool {
+	addrPort, err := netip.ParseAddrPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range a.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestBaseURL rebuilds the scheme and host a request was sent to. The
+// X-Forwarded-Proto/-Host of a TLS-terminating reverse proxy are only
+// honoured with forwarded set, since any other caller could point the
+// generated links at a host of its choosing.
+func requestBaseURL(r *http.Request, forwarded bool) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	if forwarded {
+		if proto := strings.ToLower(strings.TrimSpace(r.Header.Get("X-Forwarded-Proto"))); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		host = firstNonEmpty(r.Header.Get("X-Forwarded-Host"), r.Host)
+		if i := strings.IndexByte(host, ','); i >= 0 {
+			host = strings.TrimSpace(host[:i])
+		}
+	}
+	return scheme + "://" + host
+}
+
+// trustedProxyPrefixes parses VLESS_TRUSTED_PROXIES: addresses or CIDRs.
+func (c Config) trustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, raw := range c.TrustedProxies {
+		if addr, err := netip.ParseAddr(raw); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(raw)
+		if err != nil {
+			return nil, fmt.Errorf("VLESS_TRUSTED_PROXIES: %q is not an address or CIDR", raw)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func (a *apiServer) handleConnections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	resp, err := a.mgr.ListConnections(r.Context(), "")
+	if err != nil {
+		writeConnectionsError(w, err)
+		return
 	}
 	writeJSON(w, http.StatusOK, resp)
 }
 
+func (a *apiServer) handleClientConnections(w http.ResponseWriter, r *http.Request, clientID string) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	resp, err := a.mgr.ListConnections(r.Context(), clientID)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			writeClientError(w, clientID, err)
+			return
+		}
+		writeConnectionsError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (a *apiServer) handleClientKick(w http.ResponseWriter, r *http.Request, clientID string) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	closed, err := a.mgr.KickClient(r.Context(), clientID)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			writeClientError(w, clientID, err)
+			return
+		}
+		writeConnectionsError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":             "kicked",
+		"id":                 clientID,
+		"closed_connections": closed,
+	})
+}
+
+func (a *apiServer) handleClientSubscription(w http.ResponseWriter, r *http.Request, clientID string) {
+	var (
+		c   Client
+		err error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		c, err = a.mgr.GetClient(clientID)
+	case http.MethodPost:
+		c, err = a.mgr.RotateSubscriptionToken(clientID)
+	default:
+		methodNotAllowed(w, "GET, POST")
+		return
+	}
+	if err != nil {
+		writeClientError(w, clientID, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"id":               c.ID,
+		"subscription_url": a.subscriptionURL(r, c),
+	})
+}
+
+// handleSubscription is public: the token in the path is the credential.
+func (a *apiServer) handleSubscription(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	token := strings.TrimPrefix(r.URL.Path, subscriptionPathPrefix)
+	c, traffic, shareURIs, err := a.mgr.ClientBySubscriptionToken(token)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		writeManagerError(w, err)
+		return
+	}
+
+	// ?format= serves the raw profile for apps that import a config URL
+	// rather than a list of share links.
+	if r.URL.Query().Has("format") {
+		a.writeSubscriptionProfile(w, c, traffic, r.URL.Query().Get("format"))
+		return
+	}
+
+	links := strings.Join(shareURIs, "\n")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Subscription-Userinfo", subscriptionUserinfo(c, traffic))
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, base64.StdEncoding.EncodeToString([]byte(links)))
+}
+
+func (a *apiServer) writeSubscriptionProfile(w http.ResponseWriter, c Client, traffic ClientTraffic, rawFormat string) {
+	format, err := parseClientFormat(rawFormat)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	_, profile, err := a.mgr.GetClientConfigFormat(c.ID, format)
+	if err != nil {
+		if errors.Is(err, errXrayUnsupported) {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		writeClientError(w, c.ID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", clientFormatContentType(format))
+	w.Header().Set("Subscription-Userinfo", subscriptionUserinfo(c, traffic))
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, profile.Config)
+}
+
 func (a *apiServer) handleStart(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		methodNotAllowed(w, http.MethodPost)
 		return
 	}
 	if err := a.mgr.StartInterface(); err != nil {
-		writeError(w, http.StatusInternalServerError, err)
+		writeManagerError(w, err)
 		return
 	}
 	writeJSON(w, http.StatusOK, map[string]string{"status": "started"})
@@ -195,6 +589,32 @@ func (a *apiServer) handleStop(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "stopped"})
 }
 
+func (a *apiServer) handleRegenerateCertificate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	force := false
+	if raw := strings.TrimSpace(r.URL.Query().Get("force")); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid force %q", raw))
+			return
+		}
+		force = parsed
+	}
+	cert, err := a.mgr.RegenerateCertificate(r.Context(), force)
+	if err != nil {
+		if errors.Is(err, ErrCertificateNotGenerated) {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeManagerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"certificate": cert})
+}
+
 func writeJSON(w http.ResponseWriter, statusCode int, payload any) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(statusCode)
@@ -207,6 +627,42 @@ func writeError(w http.ResponseWriter, statusCode int, err error) {
 	})
 }
 
+func writeClientError(w http.ResponseWriter, clientID string, err error) {
+	if errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("client %s not found", clientID))
+		return
+	}
+	writeManagerError(w, err)
+}
+
+func writeManagerError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errInvalidClient):
+		writeError(w, http.StatusBadRequest, err)
+		return
+	case errors.Is(err, ErrStatsAPIDisabled):
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	var checkErr *ConfigCheckError
+	if errors.As(err, &checkErr) {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{
+			"error":  err.Error(),
+			"output": checkErr.Output,
+		})
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
+
+func writeConnectionsError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrClashAPIDisabled) {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeError(w, http.StatusBadGateway, err)
+}
+
 func methodNotAllowed(w http.ResponseWriter, allow string) {
 	w.Header().Set("Allow", allow)
 	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
@@ -235,9 +691,13 @@ func accessLogMiddleware(logger *log.Logger, next http.Handler) http.Handler {
 		start := time.Now()
 		lw := &loggingWriter{ResponseWriter: w, statusCode: http.StatusOK}
 		next.ServeHTTP(lw, r)
+		path := r.URL.Path
+		if strings.HasPrefix(path, subscriptionPathPrefix) {
+			path = subscriptionPathPrefix + "..."
+		}
 		logger.Printf("%s %s status=%d duration=%s remote=%s",
 			r.Method,
-			r.URL.Path,
+			path,
 			lw.statusCode,
 			time.Since(start).Truncate(time.Millisecond),
 			r.RemoteAddr,
@@ -273,7 +733,7 @@ func apiAuthMiddleware(apiToken string, next http.Handler) http.Handler {
 }
 
 func requiresAPIAuth(r *http.Request) bool {
-	return r.URL.Path != "/status"
+	return r.URL.Path != "/status" && !strings.HasPrefix(r.URL.Path, subscriptionPathPrefix)
 }
 
 func requestAPIToken(r *http.Request) string {
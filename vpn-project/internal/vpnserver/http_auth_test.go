@@ -12,6 +12,11 @@ func TestRequiresAPIAuth(t *testing.T) {
 		t.Fatalf("/status must stay publicly readable")
 	}
 
+	subReq := httptest.NewRequest(http.MethodGet, "http://localhost/sub/some-token", nil)
+	if requiresAPIAuth(subReq) {
+		t.Fatalf("subscription links must be fetchable without the API token")
+	}
+
 	protectedReq := httptest.NewRequest(http.MethodGet, "http://localhost/clients/default-client/config", nil)
 	if !requiresAPIAuth(protectedReq) {
 		t.Fatalf("client config endpoint must require auth")
@@ -0,0 +1,77 @@
+package vpnserver
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestResolveClientExpiry(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	got, err := resolveClientExpiry(nil, "48h", now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got == nil || !got.Equal(now.Add(48*time.Hour)) {
+		t.Fatalf("got %v, want %v", got, now.Add(48*time.Hour))
+	}
+
+	past := now.Add(-time.Hour)
+	if _, err := resolveClientExpiry(&past, "", now); err == nil {
+		t.Fatalf("expires_at in the past must be rejected")
+	}
+
+	future := now.Add(time.Hour)
+	if _, err := resolveClientExpiry(&future, "1h", now); err == nil {
+		t.Fatalf("expires_at and ttl together must be rejected")
+	}
+
+	if got, err := resolveClientExpiry(nil, "", now); err != nil || got != nil {
+		t.Fatalf("got %v, %v; want no expiry", got, err)
+	}
+}
+
+func TestClientListQuery_FilterSortPage(t *testing.T) {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clients := []Client{
+		{ID: "alice", Name: "Alice", CreatedAt: base.Add(2 * time.Hour)},
+		{ID: "alex", Name: "alex", CreatedAt: base},
+		{ID: "bob", Name: "Bob", CreatedAt: base.Add(time.Hour)},
+	}
+
+	q, err := parseClientListQuery(url.Values{
+		"prefix": {"al"},
+		"sort":   {"-created_at"},
+		"limit":  {"1"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	page, total := q.apply(clients)
+	if total != 2 {
+		t.Fatalf("got total %d, want %d", total, 2)
+	}
+	if len(page) != 1 || page[0].ID != "alice" {
+		t.Fatalf("unexpected page: %#v", page)
+	}
+
+	q.Offset = 1
+	page, _ = q.apply(clients)
+	if len(page) != 1 || page[0].ID != "alex" {
+		t.Fatalf("unexpected second page: %#v", page)
+	}
+}
+
+func TestParseClientListQuery_RejectsInvalidValues(t *testing.T) {
+	for _, values := range []url.Values{
+		{"sort": {"uuid"}},
+		{"limit": {"0"}},
+		{"offset": {"-1"}},
+	} {
+		if _, err := parseClientListQuery(values); err == nil {
+			t.Fatalf("expected error for %v", values)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package vpnserver
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+type InboundConfig struct {
+	Tag        string
+	Protocol   string
+	Port       int
+	PublicPort int
+	Security   string
+	Transport  string
+}
+
+// parseExtraInbounds reads VLESS_EXTRA_INBOUNDS, a ";"-separated list of
+// "key=value" entries such as "port=8443,security=reality;port=2053,transport=grpc;protocol=hysteria2,port=443".
+// Entries without a valid port are skipped and returned as is so the caller
+// can log them.
+func parseExtraInbounds(raw string) ([]InboundConfig, []string) {
+	var out []InboundConfig
+	var skipped []string
+	for _, entry := range strings.Split(raw, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		var in InboundConfig
+		for _, field := range splitAndTrimCSV(entry) {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			key = strings.ToLower(strings.TrimSpace(key))
+			value = strings.TrimSpace(value)
+			switch key {
+			case "tag":
+				in.Tag = value
+			case "protocol":
+				in.Protocol = value
+			case "port":
+				in.Port, _ = strconv.Atoi(value)
+			case "public_port":
+				in.PublicPort, _ = strconv.Atoi(value)
+			case "security":
+				in.Security = normalizeSecurity(value)
+			case "transport":
+				in.Transport = value
+			}
+		}
+		if in.Port <= 0 || in.Port > 65535 {
+			skipped = append(skipped, entry)
+			continue
+		}
+		in.Protocol = normalizeProtocol(in.Protocol)
+		if in.Security == "" || !protocols[in.Protocol].supportsReality() {
+			in.Security = SecurityTLS
+		}
+		in.Transport = normalizeTransport(in.Transport, in.Security)
+		if in.Tag == "" || isPrimaryInboundTag(in.Tag) {
+			in.Tag = defaultInboundTag(in)
+		}
+		out = append(out, in)
+	}
+	return out, skipped
+}
+
+// listenerNetworks reports which sockets an inbound binds: Shadowsocks
+// serves TCP and UDP on the same port, Hysteria2 only UDP.
+func listenerNetworks(protocol string) []string {
+	switch protocol {
+	case ProtocolShadowsocks:
+		return []string{"tcp", "udp"}
+	case ProtocolHysteria2:
+		return []string{"udp"}
+	default:
+		return []string{"tcp"}
+	}
+}
+
+// checkInbounds refuses listeners sing-box could not start: two inbounds
+// bound to the same port and network, or sharing a tag. sing-box would fail
+// to bind and the supervisor would restart it in a loop.
+func (c Config) checkInbounds() error {
+	tags := map[string]string{}
+	ports := map[string]string{}
+	for _, in := range c.inboundConfigs() {
+		if prev, dup := tags[in.Tag]; dup {
+			return fmt.Errorf("VLESS_EXTRA_INBOUNDS: tag %q is used by two listeners (%s)", in.Tag, prev)
+		}
+		tags[in.Tag] = in.Tag
+		for _, network := range listenerNetworks(in.Protocol) {
+			key := fmt.Sprintf("%s/%d", network, in.Port)
+			if prev, dup := ports[key]; dup {
+				return fmt.Errorf("VLESS_EXTRA_INBOUNDS: listener %s uses %s port %d already taken by %s", in.Tag, strings.ToUpper(network), in.Port, prev)
+			}
+			ports[key] = in.Tag
+		}
+	}
+	return nil
+}
+
+func defaultInboundTag(in InboundConfig) string {
+	switch in.Protocol {
+	case ProtocolShadowsocks, ProtocolHysteria2:
+		return fmt.Sprintf("%s-%d", in.Protocol, in.Port)
+	default:
+		return fmt.Sprintf("%s-%s-%s-%d", in.Protocol, in.Transport, in.Security, in.Port)
+	}
+}
+
+func primaryInboundTag(protocol string) string {
+	return protocol + "-in"
+}
+
+func isPrimaryInboundTag(tag string) bool {
+	for name := range protocols {
+		if tag == primaryInboundTag(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Config) inboundConfigs() []InboundConfig {
+	primary := InboundConfig{
+		Tag:       primaryInboundTag(c.protocolType()),
+		Protocol:  c.protocolType(),
+		Port:      c.ListenPort,
+		Security:  c.securityType(),
+		Transport: c.transportType(),
+	}
+	return append([]InboundConfig{primary}, c.ExtraInbounds...)
+}
+
+func (c Config) forInbound(in InboundConfig) Config {
+	if in.Tag == primaryInboundTag(c.protocolType()) {
+		return c
+	}
+
+	out := c
+	out.Protocol = in.Protocol
+	out.ListenPort = in.Port
+	out.Security = in.Security
+	out.Transport = in.Transport
+
+	publicPort := in.PublicPort
+	if publicPort <= 0 {
+		publicPort = in.Port
+	}
+	host, _ := resolveEndpointHostPort(c.EndpointHost, c.ListenPort)
+	out.EndpointHost = net.JoinHostPort(host, strconv.Itoa(publicPort))
+	return out
+}
+
+func (c Config) usesSecurity(security string) bool {
+	for _, in := range c.inboundConfigs() {
+		if in.Security == security {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Config) usesProtocol(protocol string) bool {
+	for _, in := range c.inboundConfigs() {
+		if in.Protocol == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Config) statusInbounds() []StatusInbound {
+	inbounds := c.inboundConfigs()
+	out := make([]StatusInbound, 0, len(inbounds))
+	for _, in := range inbounds {
+		out = append(out, StatusInbound{
+			Tag:       in.Tag,
+			Protocol:  in.Protocol,
+			Port:      in.Port,
+			Transport: c.forInbound(in).transportLabel(),
+		})
+	}
+	return out
+}
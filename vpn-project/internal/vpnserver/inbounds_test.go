@@ -0,0 +1,98 @@
+package vpnserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseExtraInbounds(t *testing.T) {
+	got, skipped := parseExtraInbounds(" port=8443,security=reality ; port=2053,transport=grpc,public_port=443,tag=cdn ; transport=ws ")
+	if len(got) != 2 {
+		t.Fatalf("got %d inbounds, want 2: %#v", len(got), got)
+	}
+	if len(skipped) != 1 || skipped[0] != "transport=ws" {
+		t.Fatalf("entry without a port must be reported as skipped, got %#v", skipped)
+	}
+	if got[0].Tag != "vless-tcp-reality-8443" || got[0].Transport != TransportTCP || got[0].Security != SecurityReality {
+		t.Fatalf("unexpected reality inbound: %#v", got[0])
+	}
+	if got[1].Tag != "cdn" || got[1].Transport != TransportGRPC || got[1].Security != SecurityTLS || got[1].PublicPort != 443 {
+		t.Fatalf("unexpected grpc inbound: %#v", got[1])
+	}
+}
+
+func TestMultipleInbounds_ShareUsersAndUseURLTest(t *testing.T) {
+	cfg := Config{
+		EndpointHost:       "vpn.example.com",
+		ListenPort:         443,
+		WebsocketPath:      "/vpn",
+		TLSServerName:      "vpn.example.com",
+		RealityServerName:  "www.example.org",
+		RealityFingerprint: "chrome",
+		RealityPublicKey:   "pub-key",
+		RealityShortIDs:    []string{"abcd"},
+	}
+	cfg.ExtraInbounds, _ = parseExtraInbounds("port=8443,security=reality")
+	clients := []Client{{Name: "alice", UUID: "11111111-1111-1111-1111-111111111111"}}
+
+	server := buildServerConfigMap(cfg, clients)
+	inbounds := server["inbounds"].([]any)
+	if len(inbounds) != 2 {
+		t.Fatalf("got %d server inbounds, want 2", len(inbounds))
+	}
+	for _, raw := range inbounds {
+		inbound := raw.(map[string]any)
+		users := inbound["users"].([]map[string]string)
+		if len(users) != 1 || users[0]["uuid"] != clients[0].UUID {
+			t.Fatalf("inbound %v must share users, got %#v", inbound["tag"], users)
+		}
+	}
+	if inbounds[1].(map[string]any)["listen_port"] != 8443 {
+		t.Fatalf("extra inbound must listen on its own port")
+	}
+
+	client := buildClientConfigMap(cfg, clients[0])
+	outbounds := client["outbounds"].([]any)
+	selector := outbounds[0].(map[string]any)
+	if selector["type"] != "urltest" || selector["tag"] != "proxy" {
+		t.Fatalf("first outbound must be the urltest selector: %#v", selector)
+	}
+	members := selector["outbounds"].([]string)
+	if len(members) != 2 || members[0] != "proxy-vless-in" || members[1] != "proxy-vless-tcp-reality-8443" {
+		t.Fatalf("unexpected urltest members: %#v", members)
+	}
+	reality := outbounds[2].(map[string]any)
+	if reality["server_port"] != 8443 || reality["flow"] != "xtls-rprx-vision" {
+		t.Fatalf("unexpected reality outbound: %#v", reality)
+	}
+
+	uris := buildClientShareURIs(cfg, clients[0])
+	if len(uris) != 2 || !strings.Contains(uris[1], "security=reality") || !strings.Contains(uris[1], ":8443") {
+		t.Fatalf("unexpected share uris: %#v", uris)
+	}
+	if uris[0] != buildClientShareURI(cfg, clients[0]) {
+		t.Fatalf("primary share uri must come first")
+	}
+}
+
+func TestCheckInbounds_RejectsConflictingListeners(t *testing.T) {
+	cases := map[string]string{
+		"primary port":   "port=443",
+		"duplicate tag":  "port=8443,tag=cdn;port=2053,tag=cdn",
+		"duplicate port": "port=8443,transport=grpc;port=8443,security=reality",
+		"ss and hy2 udp": "protocol=shadowsocks,port=8388;protocol=hysteria2,port=8388",
+	}
+	for name, raw := range cases {
+		cfg := Config{ListenPort: 443}
+		cfg.ExtraInbounds, _ = parseExtraInbounds(raw)
+		if err := cfg.checkInbounds(); err == nil {
+			t.Fatalf("%s: expected a conflict for %q", name, raw)
+		}
+	}
+
+	cfg := Config{ListenPort: 443}
+	cfg.ExtraInbounds, _ = parseExtraInbounds("protocol=hysteria2,port=443;port=8443,security=reality")
+	if err := cfg.checkInbounds(); err != nil {
+		t.Fatalf("hysteria2 over UDP may share the TCP port: %v", err)
+	}
+}
@@ -6,13 +6,18 @@ import (
 	"os"
 )
 
-func NewLogger() *log.Logger {
+func NewLogger() (*log.Logger, func()) {
 	logWriters := []io.Writer{os.Stdout}
+	closeLog := func() {}
 	if err := os.MkdirAll("/var/log", 0o755); err == nil {
 		f, err := os.OpenFile("/var/log/vpn-api.log", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
 		if err == nil {
 			logWriters = append(logWriters, f)
+			closeLog = func() {
+				_ = f.Sync()
+				_ = f.Close()
+			}
 		}
 	}
-	return log.New(io.MultiWriter(logWriters...), "[vpn-server] ", log.LstdFlags|log.LUTC)
+	return log.New(io.MultiWriter(logWriters...), "[vpn-server] ", log.LstdFlags|log.LUTC), closeLog
 }
@@ -1,19 +1,14 @@
 package vpnserver
 
 import (
+	"bytes"
 	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"crypto/x509/pkix"
 	"encoding/hex"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"fmt"
 	"log"
-	"math/big"
 	"net"
-	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -26,32 +21,100 @@ import (
 )
 
 type Client struct {
-	ID         string    `json:"id"`
-	Name       string    `json:"name"`
-	UUID       string    `json:"uuid"`
-	Address    string    `json:"address,omitempty"` // legacy field kept for API compatibility
-	ConfigPath string    `json:"config_path"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	UUID       string `json:"uuid"`
+	Address    string `json:"address,omitempty"` // legacy field kept for API compatibility
+	ConfigPath string `json:"config_path"`
+
+	Password       string `json:"password,omitempty"`
+	ShadowsocksKey string `json:"shadowsocks_key,omitempty"`
+
+	SubscriptionToken string `json:"subscription_token,omitempty"`
+
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Disabled  bool       `json:"disabled"`
+
+	Tags   []string          `json:"tags,omitempty"`
+	Notes  string            `json:"notes,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+
+	Quota           *ClientQuota `json:"quota,omitempty"`
+	QuotaExceededAt *time.Time   `json:"quota_exceeded_at,omitempty"`
+
+	MaxDevices       int               `json:"max_devices,omitempty"`
+	DeviceViolations []DeviceViolation `json:"device_violations,omitempty"`
+
+	userName string
+}
+
+type ClientUpdate struct {
+	Name   *string            `json:"name"`
+	Tags   *[]string          `json:"tags"`
+	Notes  *string            `json:"notes"`
+	Labels *map[string]string `json:"labels"`
+	Quota  *ClientQuota       `json:"quota"`
+
+	MaxDevices *int `json:"max_devices"`
+}
+
+// errInvalidClient wraps client fields the manager refuses; the HTTP API
+// answers them with 400.
+var errInvalidClient = errors.New("invalid client")
+
+func (c Client) expired(now time.Time) bool {
+	return c.ExpiresAt != nil && !now.Before(*c.ExpiresAt)
+}
+
+func (c Client) active(now time.Time) bool {
+	return !c.Disabled && !c.expired(now) && c.QuotaExceededAt == nil
+}
+
+// ClientProfile is a rendered client config together with the share links
+// built from the same server state, so a certificate rotation cannot pair a
+// config with links that carry another pin.
+type ClientProfile struct {
+	Config    string
+	ShareURI  string
+	ShareURIs []string
 }
 
 type StatusClient struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	UUID      string    `json:"uuid"`
-	Address   string    `json:"address,omitempty"` // legacy field kept for API compatibility
-	CreatedAt time.Time `json:"created_at"`
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	UUID      string     `json:"uuid"`
+	Address   string     `json:"address,omitempty"` // legacy field kept for API compatibility
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Expired   bool       `json:"expired"`
+	Disabled  bool       `json:"disabled"`
+
+	QuotaExceeded bool           `json:"quota_exceeded,omitempty"`
+	Traffic       *ClientTraffic `json:"traffic,omitempty"`
+}
+
+type StatusInbound struct {
+	Tag       string `json:"tag"`
+	Protocol  string `json:"protocol"`
+	Port      int    `json:"port"`
+	Transport string `json:"transport"`
 }
 
 type StatusResponse struct {
-	Running         bool           `json:"running"`
-	Interface       string         `json:"interface"`
-	ListenPort      int            `json:"listen_port"`
-	ServerPublicKey string         `json:"server_public_key,omitempty"` // legacy field
-	ClientSubnet    string         `json:"client_subnet,omitempty"`     // legacy field
-	Protocol        string         `json:"protocol"`
-	Transport       string         `json:"transport"`
-	Endpoint        string         `json:"endpoint"`
-	Clients         []StatusClient `json:"clients"`
+	Running         bool               `json:"running"`
+	Interface       string             `json:"interface"`
+	ListenPort      int                `json:"listen_port"`
+	ServerPublicKey string             `json:"server_public_key,omitempty"` // legacy field
+	ClientSubnet    string             `json:"client_subnet,omitempty"`     // legacy field
+	Protocol        string             `json:"protocol"`
+	Transport       string             `json:"transport"`
+	Endpoint        string             `json:"endpoint"`
+	Inbounds        []StatusInbound    `json:"inbounds"`
+	TLSCertSHA256   string             `json:"tls_cert_sha256,omitempty"`
+	Certificate     *StatusCertificate `json:"certificate,omitempty"`
+	Supervisor      SupervisorStatus   `json:"supervisor"`
+	Clients         []StatusClient     `json:"clients"`
 }
 
 type Manager struct {
@@ -65,6 +128,17 @@ type Manager struct {
 	serverConfigPath string
 	serverLogPath    string
 	serverCmd        *exec.Cmd
+	serverDone       chan struct{}
+	applied          serverConfigSnapshot
+	configUsers      map[string]string
+	supervisor       supervisorState
+	checkSkipLogged  bool
+	stats            *statsClient
+	unbankedTraffic  map[string]int64
+	clash            *clashClient
+
+	// configActive holds the client IDs server.json was last written with.
+	configActive map[string]bool
 }
 
 var clientIDRe = regexp.MustCompile(`[^a-z0-9._-]+`)
@@ -100,16 +174,46 @@ func (m *Manager) InitState() error {
 		return fmt.Errorf("chmod %s: %w", m.clientsDir, err)
 	}
 
+	for _, entry := range m.cfg.SkippedInbounds {
+		m.logger.Printf("WARNING: skipping VLESS_EXTRA_INBOUNDS entry %q: it needs port=1-65535", entry)
+	}
+	if err := m.cfg.checkInbounds(); err != nil {
+		return err
+	}
+	if err := m.cfg.checkShadowsocksMethod(); err != nil {
+		return err
+	}
+	if err := m.cfg.checkACMEBind(); err != nil {
+		return err
+	}
+	if _, err := m.cfg.trustedProxyPrefixes(); err != nil {
+		return err
+	}
+	if err := m.checkSingBoxTagsLocked(); err != nil {
+		return err
+	}
 	if err := m.ensureTLSMaterialLocked(); err != nil {
 		return err
 	}
+	if err := m.refreshCertPinLocked(); err != nil {
+		return err
+	}
+	if err := m.ensureRealityMaterialLocked(); err != nil {
+		return err
+	}
+	if err := m.ensureShadowsocksMaterialLocked(); err != nil {
+		return err
+	}
+	if err := m.ensureClashAPIMaterialLocked(); err != nil {
+		return err
+	}
 
 	clients, err := m.loadClientsLocked()
 	if err != nil {
 		return err
 	}
 	if len(clients) == 0 {
-		if _, _, err := m.createClientLocked("default-client", clients); err != nil {
+		if _, _, err := m.createClientLocked("default-client", nil, clients); err != nil {
 			return err
 		}
 		clients, err = m.loadClientsLocked()
@@ -118,21 +222,233 @@ func (m *Manager) InitState() error {
 		}
 	}
 
-	if err := m.rewriteServerConfigLocked(clients); err != nil {
+	if _, err := m.rewriteServerConfigLocked(clients); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (m *Manager) CreateClient(name string) (Client, string, error) {
+func (m *Manager) CreateClient(name string, expiresAt *time.Time) (Client, ClientProfile, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	clients, err := m.loadClientsLocked()
 	if err != nil {
-		return Client{}, "", err
+		return Client{}, ClientProfile{}, err
 	}
-	return m.createClientLocked(name, clients)
+	return m.createClientLocked(name, expiresAt, clients)
+}
+
+func (m *Manager) DeleteClient(clientID string) (Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clients, err := m.loadClientsLocked()
+	if err != nil {
+		return Client{}, err
+	}
+
+	c, ok := clients[clientID]
+	if !ok {
+		return Client{}, os.ErrNotExist
+	}
+
+	delete(clients, clientID)
+	if _, err := m.commitClientsLocked(clients); err != nil {
+		return Client{}, err
+	}
+	if err := os.Remove(c.ConfigPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Client{}, fmt.Errorf("remove client config: %w", err)
+	}
+	if err := m.reloadInterfaceLocked(); err != nil {
+		return Client{}, fmt.Errorf("reload sing-box after deleting client: %w", err)
+	}
+
+	m.logger.Printf("client %s deleted", c.ID)
+	return c, nil
+}
+
+func (m *Manager) SetClientDisabled(clientID string, disabled bool) (Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clients, err := m.loadClientsLocked()
+	if err != nil {
+		return Client{}, err
+	}
+
+	c, ok := clients[clientID]
+	if !ok {
+		return Client{}, os.ErrNotExist
+	}
+	if c.Disabled == disabled {
+		return c, nil
+	}
+
+	c.Disabled = disabled
+	clients[clientID] = c
+	if _, err := m.commitClientsLocked(clients); err != nil {
+		return Client{}, err
+	}
+	if err := m.reloadInterfaceLocked(); err != nil {
+		return Client{}, fmt.Errorf("reload sing-box after updating client: %w", err)
+	}
+
+	if disabled {
+		m.logger.Printf("client %s disabled", c.ID)
+	} else {
+		m.logger.Printf("client %s enabled", c.ID)
+	}
+	return c, nil
+}
+
+func (m *Manager) UpdateClient(clientID string, upd ClientUpdate) (Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clients, err := m.loadClientsLocked()
+	if err != nil {
+		return Client{}, err
+	}
+
+	c, ok := clients[clientID]
+	if !ok {
+		return Client{}, os.ErrNotExist
+	}
+
+	if upd.Name != nil {
+		name := strings.TrimSpace(*upd.Name)
+		if name == "" {
+			return Client{}, fmt.Errorf("%w: name must not be empty", errInvalidClient)
+		}
+		c.Name = name
+	}
+	if upd.Tags != nil {
+		c.Tags = normalizeTags(*upd.Tags)
+	}
+	if upd.Notes != nil {
+		c.Notes = strings.TrimSpace(*upd.Notes)
+	}
+	if upd.Labels != nil {
+		c.Labels = normalizeLabels(*upd.Labels)
+	}
+	if upd.Quota != nil {
+		quota, err := normalizeQuota(*upd.Quota)
+		if err != nil {
+			return Client{}, err
+		}
+		if quota != nil && m.cfg.StatsAPIListen == "" {
+			return Client{}, ErrStatsAPIDisabled
+		}
+		c.Quota = quota
+	}
+	if upd.MaxDevices != nil {
+		if *upd.MaxDevices < 0 {
+			return Client{}, fmt.Errorf("%w: max_devices must not be negative", errInvalidClient)
+		}
+		c.MaxDevices = *upd.MaxDevices
+	}
+	clients[clientID] = c
+
+	if upd.Quota != nil {
+		if _, err := m.enforceQuotasLocked(clients, time.Now().UTC()); err != nil {
+			return Client{}, err
+		}
+		c = clients[clientID]
+	}
+	changed, err := m.commitClientsLocked(clients)
+	if err != nil {
+		return Client{}, err
+	}
+	if changed {
+		if err := m.reloadInterfaceLocked(); err != nil {
+			return Client{}, fmt.Errorf("reload sing-box after updating client: %w", err)
+		}
+	}
+	return c, nil
+}
+
+func (m *Manager) RotateClientCredentials(clientID string) (Client, ClientProfile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clients, err := m.loadClientsLocked()
+	if err != nil {
+		return Client{}, ClientProfile{}, err
+	}
+
+	c, ok := clients[clientID]
+	if !ok {
+		return Client{}, ClientProfile{}, os.ErrNotExist
+	}
+
+	c.UUID, c.Address, c.Password, c.ShadowsocksKey = "", "", "", ""
+	if _, err := fillClientCredentials(&c, m.cfg.ShadowsocksMethod); err != nil {
+		return Client{}, ClientProfile{}, err
+	}
+	clients[clientID] = c
+
+	if _, err := m.commitClientsLocked(clients); err != nil {
+		return Client{}, ClientProfile{}, err
+	}
+	if err := m.reloadInterfaceLocked(); err != nil {
+		return Client{}, ClientProfile{}, fmt.Errorf("reload sing-box after rotating client credentials: %w", err)
+	}
+
+	raw, err := os.ReadFile(c.ConfigPath)
+	if err != nil {
+		return Client{}, ClientProfile{}, fmt.Errorf("read generated client config: %w", err)
+	}
+	m.logger.Printf("client %s credentials rotated", c.ID)
+	return c, m.clientProfileLocked(c, string(raw)), nil
+}
+
+func (m *Manager) EnforceExpiry() (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clients, err := m.loadClientsLocked()
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().UTC()
+	marked, err := m.enforceQuotasLocked(clients, now)
+	if err != nil {
+		return false, err
+	}
+	if !marked && !m.expiredSinceWriteLocked(clients, now) {
+		return false, nil
+	}
+	var changed bool
+	if marked {
+		changed, err = m.commitClientsLocked(clients)
+	} else {
+		changed, err = m.rewriteServerConfigLocked(clients)
+	}
+	if err != nil {
+		return false, err
+	}
+	if !changed {
+		return false, nil
+	}
+	if err := m.reloadInterfaceLocked(); err != nil {
+		return true, fmt.Errorf("reload sing-box after expiry check: %w", err)
+	}
+	return true, nil
+}
+
+// expiredSinceWriteLocked reports whether a client server.json still serves
+// has expired, so the periodic check only rewrites configs when it must.
+func (m *Manager) expiredSinceWriteLocked(clients map[string]Client, now time.Time) bool {
+	if m.configActive == nil {
+		return true
+	}
+	for id, c := range clients {
+		if m.configActive[id] && c.expired(now) {
+			return true
+		}
+	}
+	return false
 }
 
 func (m *Manager) GetStatus() (StatusResponse, error) {
@@ -143,60 +459,114 @@ func (m *Manager) GetStatus() (StatusResponse, error) {
 		return StatusResponse{}, err
 	}
 	running := m.interfaceRunningLocked()
+	supervisor := m.supervisorStatusLocked()
+	certSHA256 := m.cfg.PinnedCertSHA256
+	certificate := m.certificateStatusLocked(time.Now())
+	traffic, err := m.loadTrafficLocked()
 	m.mu.Unlock()
+	if err != nil {
+		return StatusResponse{}, err
+	}
 
+	now := time.Now().UTC()
 	list := make([]StatusClient, 0, len(clients))
 	for _, c := range clients {
 		addr := strings.TrimSpace(c.Address)
 		if addr == "" {
 			addr = c.UUID
 		}
-		list = append(list, StatusClient{
+		item := StatusClient{
 			ID:        c.ID,
 			Name:      c.Name,
 			UUID:      c.UUID,
 			Address:   addr,
 			CreatedAt: c.CreatedAt,
-		})
+			ExpiresAt: c.ExpiresAt,
+			Expired:   c.expired(now),
+			Disabled:  c.Disabled,
+
+			QuotaExceeded: c.QuotaExceededAt != nil,
+		}
+		if t, ok := traffic[c.ID]; ok {
+			item.Traffic = &t
+		}
+		list = append(list, item)
 	}
 	sort.Slice(list, func(i, j int) bool {
 		return list[i].ID < list[j].ID
 	})
 
 	return StatusResponse{
-		Running:    running,
-		Interface:  m.cfg.Interface,
-		ListenPort: m.cfg.ListenPort,
-		Protocol:   "vless",
-		Transport:  "ws+tls",
-		Endpoint:   m.cfg.EndpointHost,
-		Clients:    list,
+		Running:       running,
+		Interface:     m.cfg.Interface,
+		ListenPort:    m.cfg.ListenPort,
+		Protocol:      m.cfg.protocolType(),
+		Transport:     m.cfg.transportLabel(),
+		Endpoint:      m.cfg.EndpointHost,
+		Inbounds:      m.cfg.statusInbounds(),
+		Supervisor:    supervisor,
+		TLSCertSHA256: certSHA256,
+		Certificate:   certificate,
+		Clients:       list,
 	}, nil
 }
 
-func (m *Manager) GetClientConfig(clientID string) (Client, string, error) {
+func (m *Manager) ListClients() ([]Client, error) {
+	m.mu.Lock()
+	clients, err := m.loadClientsLocked()
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Client, 0, len(clients))
+	for _, c := range clients {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+func (m *Manager) GetClient(clientID string) (Client, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	clients, err := m.loadClientsLocked()
 	if err != nil {
-		return Client{}, "", err
+		return Client{}, err
+	}
+	c, ok := clients[clientID]
+	if !ok {
+		return Client{}, os.ErrNotExist
+	}
+	return c, nil
+}
+
+func (m *Manager) GetClientConfig(clientID string) (Client, ClientProfile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clients, err := m.loadClientsLocked()
+	if err != nil {
+		return Client{}, ClientProfile{}, err
 	}
 
 	c, ok := clients[clientID]
 	if !ok {
-		return Client{}, "", os.ErrNotExist
+		return Client{}, ClientProfile{}, os.ErrNotExist
 	}
 
 	if err := m.writeClientConfigLocked(c); err != nil {
-		return Client{}, "", err
+		return Client{}, ClientProfile{}, err
 	}
 
 	raw, err := os.ReadFile(c.ConfigPath)
 	if err != nil {
-		return Client{}, "", fmt.Errorf("read client config: %w", err)
+		return Client{}, ClientProfile{}, fmt.Errorf("read client config: %w", err)
 	}
-	return c, string(raw), nil
+	return c, m.clientProfileLocked(c, string(raw)), nil
 }
 
 func (m *Manager) StartInterface() error {
@@ -207,10 +577,11 @@ func (m *Manager) StartInterface() error {
 	if err != nil {
 		return err
 	}
-	if err := m.rewriteServerConfigLocked(clients); err != nil {
+	if _, err := m.rewriteServerConfigLocked(clients); err != nil {
 		return err
 	}
 
+	m.resetSupervisorLocked(true)
 	if m.interfaceRunningLocked() {
 		return nil
 	}
@@ -228,6 +599,12 @@ func (m *Manager) startInterfaceLocked() error {
 		return fmt.Errorf("open sing-box log: %w", err)
 	}
 
+	snapshot, err := m.snapshotServerConfigLocked()
+	if err != nil {
+		_ = logFile.Close()
+		return err
+	}
+
 	cmd := exec.Command(m.cfg.SingBoxBinary, "run", "-c", m.serverConfigPath)
 	cmd.Stdout = logFile
 	cmd.Stderr = logFile
@@ -236,25 +613,26 @@ func (m *Manager) startInterfaceLocked() error {
 		return fmt.Errorf("start sing-box: %w", err)
 	}
 
+	done := make(chan struct{})
 	m.serverCmd = cmd
+	m.serverDone = done
+	m.applied = snapshot
 	m.logger.Printf("vless server started with sing-box (pid=%d)", cmd.Process.Pid)
 
-	go func(cmd *exec.Cmd, outFile *os.File) {
+	go func(cmd *exec.Cmd, outFile *os.File, done chan struct{}) {
 		err := cmd.Wait()
 		_ = outFile.Close()
+		close(done)
 
 		m.mu.Lock()
-		if m.serverCmd == cmd {
-			m.serverCmd = nil
-		}
-		m.mu.Unlock()
-
-		if err != nil {
-			m.logger.Printf("sing-box exited with error: %v", err)
+		defer m.mu.Unlock()
+		if m.serverCmd != cmd {
+			m.logger.Printf("sing-box exited")
 			return
 		}
-		m.logger.Printf("sing-box exited")
-	}(cmd, logFile)
+		m.serverCmd = nil
+		m.handleUnexpectedExitLocked(err)
+	}(cmd, logFile, done)
 
 	return nil
 }
@@ -263,6 +641,7 @@ func (m *Manager) StopInterface() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	m.resetSupervisorLocked(false)
 	m.stopInterfaceLocked()
 	return nil
 }
@@ -271,6 +650,7 @@ func (m *Manager) stopInterfaceLocked() {
 	if !m.interfaceRunningLocked() {
 		return
 	}
+	m.flushTrafficLocked()
 
 	cmd := m.serverCmd
 	if cmd == nil || cmd.Process == nil {
@@ -278,57 +658,72 @@ func (m *Manager) stopInterfaceLocked() {
 		return
 	}
 
-	_ = cmd.Process.Signal(os.Interrupt)
-	time.Sleep(350 * time.Millisecond)
-	_ = cmd.Process.Kill()
+	grace := m.cfg.StopGracePeriod
+	if grace <= 0 {
+		grace = 350 * time.Millisecond
+	}
+
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		_ = cmd.Process.Kill()
+	} else {
+		select {
+		case <-m.serverDone:
+		case <-time.After(grace):
+			m.logger.Printf("sing-box did not exit within %s, killing", grace)
+			_ = cmd.Process.Kill()
+		}
+	}
 
 	m.serverCmd = nil
+	m.serverDone = nil
 	m.logger.Printf("vless server stopped")
 }
 
-func (m *Manager) ClientShareURI(c Client) string {
-	return buildClientShareURI(m.cfg, c)
+func (m *Manager) clientProfileLocked(c Client, config string) ClientProfile {
+	return ClientProfile{
+		Config:    config,
+		ShareURI:  buildClientShareURI(m.cfg, c),
+		ShareURIs: buildClientShareURIs(m.cfg, c),
+	}
 }
 
-func (m *Manager) createClientLocked(name string, clients map[string]Client) (Client, string, error) {
+func (m *Manager) createClientLocked(name string, expiresAt *time.Time, clients map[string]Client) (Client, ClientProfile, error) {
 	id := allocateClientIDLocked(name, clients)
-	userUUID, err := generateUUID()
-	if err != nil {
-		return Client{}, "", err
-	}
-
 	configPath := filepath.Join(m.clientsDir, id+".json")
 	c := Client{
 		ID:         id,
 		Name:       strings.TrimSpace(name),
-		UUID:       userUUID,
-		Address:    userUUID,
 		ConfigPath: configPath,
 		CreatedAt:  time.Now().UTC(),
 	}
+	if _, err := fillClientCredentials(&c, m.cfg.ShadowsocksMethod); err != nil {
+		return Client{}, ClientProfile{}, err
+	}
+	if _, err := fillSubscriptionToken(&c); err != nil {
+		return Client{}, ClientProfile{}, err
+	}
 	if c.Name == "" {
 		c.Name = id
 	}
+	if expiresAt != nil {
+		exp := expiresAt.UTC()
+		c.ExpiresAt = &exp
+	}
 
 	clients[c.ID] = c
-	if err := m.saveClientsLocked(clients); err != nil {
-		return Client{}, "", err
+	if _, err := m.commitClientsLocked(clients); err != nil {
+		delete(clients, c.ID)
+		return Client{}, ClientProfile{}, err
 	}
-	if err := m.rewriteServerConfigLocked(clients); err != nil {
-		return Client{}, "", err
-	}
-	if m.interfaceRunningLocked() {
-		m.stopInterfaceLocked()
-		if err := m.startInterfaceLocked(); err != nil {
-			return Client{}, "", fmt.Errorf("reload sing-box after creating client: %w", err)
-		}
+	if err := m.reloadInterfaceLocked(); err != nil {
+		return Client{}, ClientProfile{}, fmt.Errorf("reload sing-box after creating client: %w", err)
 	}
 
 	raw, err := os.ReadFile(c.ConfigPath)
 	if err != nil {
-		return Client{}, "", fmt.Errorf("read generated client config: %w", err)
+		return Client{}, ClientProfile{}, fmt.Errorf("read generated client config: %w", err)
 	}
-	return c, string(raw), nil
+	return c, m.clientProfileLocked(c, string(raw)), nil
 }
 
 func (m *Manager) loadClientsLocked() (map[string]Client, error) {
@@ -358,18 +753,16 @@ func (m *Manager) loadClientsLocked() (map[string]Client, error) {
 			c.Name = id
 			changed = true
 		}
-		if strings.TrimSpace(c.UUID) == "" {
-			u, err := generateUUID()
-			if err != nil {
-				return nil, err
-			}
-			c.UUID = u
-			changed = true
+		filled, err := fillClientCredentials(&c, m.cfg.ShadowsocksMethod)
+		if err != nil {
+			return nil, err
 		}
-		if strings.TrimSpace(c.Address) == "" {
-			c.Address = c.UUID
-			changed = true
+		changed = changed || filled
+		filled, err = fillSubscriptionToken(&c)
+		if err != nil {
+			return nil, err
 		}
+		changed = changed || filled
 		if strings.TrimSpace(c.ConfigPath) == "" {
 			c.ConfigPath = filepath.Join(m.clientsDir, c.ID+".json")
 			changed = true
@@ -410,7 +803,21 @@ func (m *Manager) saveClientsLocked(clients map[string]Client) error {
 	return nil
 }
 
-func (m *Manager) rewriteServerConfigLocked(clients map[string]Client) error {
+// commitClientsLocked applies clients to server.json and persists them only
+// once the new config has passed `sing-box check`, so a rejected change
+// leaves clients.json as it was.
+func (m *Manager) commitClientsLocked(clients map[string]Client) (bool, error) {
+	changed, err := m.rewriteServerConfigLocked(clients)
+	if err != nil {
+		return false, err
+	}
+	if err := m.saveClientsLocked(clients); err != nil {
+		return false, err
+	}
+	return changed, nil
+}
+
+func (m *Manager) rewriteServerConfigLocked(clients map[string]Client) (bool, error) {
 	list := make([]Client, 0, len(clients))
 	changed := false
 	for id, c := range clients {
@@ -431,26 +838,44 @@ func (m *Manager) rewriteServerConfigLocked(clients map[string]Client) error {
 		return list[i].ID < list[j].ID
 	})
 
+	// Taken before building: a client expiring in between is then only
+	// rewritten once more instead of being missed.
+	now := time.Now().UTC()
+	active := make(map[string]bool, len(list))
+	for _, c := range list {
+		if c.active(now) {
+			active[c.ID] = true
+		}
+	}
 	payload, err := marshalPretty(buildServerConfigMap(m.cfg, list))
 	if err != nil {
-		return fmt.Errorf("serialize server config: %w", err)
+		return false, fmt.Errorf("serialize server config: %w", err)
+	}
+	previous, err := os.ReadFile(m.serverConfigPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("read server config: %w", err)
 	}
-	if err := writeSecretFile(m.serverConfigPath, payload); err != nil {
-		return fmt.Errorf("write server config: %w", err)
+	serverChanged := !bytes.Equal(previous, payload)
+	if serverChanged {
+		if err := m.applyServerConfigLocked(payload); err != nil {
+			return false, err
+		}
 	}
+	m.configUsers = serverUserIDs(list)
+	m.configActive = active
 
 	for _, c := range list {
 		if err := m.writeClientConfigLocked(c); err != nil {
-			return err
+			return false, err
 		}
 	}
 
 	if changed {
 		if err := m.saveClientsLocked(clients); err != nil {
-			return err
+			return false, err
 		}
 	}
-	return nil
+	return serverChanged, nil
 }
 
 func (m *Manager) writeClientConfigLocked(c Client) error {
@@ -470,57 +895,43 @@ func (m *Manager) interfaceRunningLocked() bool {
 
 func (m *Manager) ensureTLSMaterialLocked() error {
 	if fileExists(m.cfg.TLSCertPath) && fileExists(m.cfg.TLSKeyPath) {
-		return nil
+		return m.adoptLegacyCertificateLocked()
 	}
 
-	commonName := strings.TrimSpace(m.cfg.TLSServerName)
-	if commonName == "" {
-		commonName = strings.TrimSpace(m.cfg.EndpointHost)
-	}
-	if commonName == "" {
-		commonName = "localhost"
+	if err := m.generateSelfSignedCertificateLocked(); err != nil {
+		return err
 	}
-
-	if err := generateSelfSignedCertificate(m.cfg.TLSCertPath, m.cfg.TLSKeyPath, commonName); err != nil {
-		return fmt.Errorf("generate self-signed tls certificate: %w", err)
+	if m.cfg.TLSMode == TLSModeACME {
+		m.logger.Printf("generated placeholder TLS certificate at %s until ACME issuance completes", m.cfg.TLSCertPath)
+		return nil
 	}
 	m.logger.Printf("generated self-signed TLS certificate at %s", m.cfg.TLSCertPath)
 	return nil
 }
 
 func buildServerConfigMap(cfg Config, clients []Client) map[string]any {
-	users := make([]map[string]string, 0, len(clients))
+	now := time.Now().UTC()
+	names := serverUserNames(clients)
+	active := make([]Client, 0, len(clients))
 	for _, c := range clients {
-		users = append(users, map[string]string{
-			"name": c.Name,
-			"uuid": c.UUID,
-		})
+		if c.active(now) {
+			c.userName = names[c.ID]
+			active = append(active, c)
+		}
 	}
 
-	return map[string]any{
+	inbounds := make([]any, 0, len(cfg.inboundConfigs()))
+	for _, in := range cfg.inboundConfigs() {
+		inCfg := cfg.forInbound(in)
+		inbounds = append(inbounds, protocolFor(inCfg).serverInbound(inCfg, in.Tag, active))
+	}
+
+	serverConfig := map[string]any{
 		"log": map[string]any{
 			"level":     "info",
 			"timestamp": true,
 		},
-		"inbounds": []any{
-			map[string]any{
-				"type":        "vless",
-				"tag":         "vless-in",
-				"listen":      cfg.ListenAddress,
-				"listen_port": cfg.ListenPort,
-				"users":       users,
-				"tls": map[string]any{
-					"enabled":          true,
-					"server_name":      cfg.TLSServerName,
-					"certificate_path": cfg.TLSCertPath,
-					"key_path":         cfg.TLSKeyPath,
-				},
-				"transport": map[string]any{
-					"type": "ws",
-					"path": cfg.WebsocketPath,
-				},
-			},
-		},
+		"inbounds": inbounds,
 		"outbounds": []any{
 			map[string]any{
 				"type": "direct",
@@ -532,26 +943,56 @@ func buildServerConfigMap(cfg Config, clients []Client) map[string]any {
 			},
 		},
 	}
+	rules := []any{serverLoopbackRule()}
+	experimental := map[string]any{}
+	if cfg.StatsAPIListen != "" {
+		experimental["v2ray_api"] = statsAPIOptions(cfg, active)
+	}
+	if cfg.ClashAPIListen != "" {
+		experimental["clash_api"] = clashAPIOptions(cfg)
+		rules = append(rules, clashUserRules(active)...)
+	}
+	serverConfig["route"] = map[string]any{
+		"rules": rules,
+		"final": "direct",
+	}
+	if len(experimental) > 0 {
+		serverConfig["experimental"] = experimental
+	}
+	return serverConfig
+}
+
+// serverLoopbackRule keeps tunnelled clients away from the server's own
+// private and loopback addresses, where the stats API, the Clash API and the
+// manager API listen without a token.
+func serverLoopbackRule() map[string]any {
+	return map[string]any{
+		"ip_is_private": true,
+		"ip_cidr":       []string{"127.0.0.0/8", "::1/128"},
+		"action":        "reject",
+	}
+}
+
+// clientPrivateCIDRs always bypass the proxy in generated client configs.
+var clientPrivateCIDRs = []string{
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"169.254.0.0/16",
+	"224.0.0.0/4",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
 }
 
 func buildClientConfigMap(cfg Config, c Client) map[string]any {
-	host, port := resolveEndpointHostPort(cfg.EndpointHost, cfg.ListenPort)
+	host, _ := resolveEndpointHostPort(cfg.EndpointHost, cfg.ListenPort)
 	tunAddresses := splitAndTrimCSV(cfg.ClientTunCIDR)
 	if len(tunAddresses) == 0 {
 		tunAddresses = []string{"172.19.0.1/30"}
 	}
 	endpointExcludeCIDRs := routeExcludeCIDRsForHost(host)
-	privateCIDRs := []string{
-		"127.0.0.0/8",
-		"10.0.0.0/8",
-		"172.16.0.0/12",
-		"192.168.0.0/16",
-		"169.254.0.0/16",
-		"224.0.0.0/4",
-		"::1/128",
-		"fc00::/7",
-		"fe80::/10",
-	}
 	tunInbound := map[string]any{
 		"type":                       "tun",
 		"tag":                        "tun-in",
@@ -567,6 +1008,18 @@ func buildClientConfigMap(cfg Config, c Client) map[string]any {
 		tunInbound["route_exclude_address"] = endpointExcludeCIDRs
 	}
 
+	outbounds := proxyOutbounds(cfg, c)
+	outbounds = append(outbounds,
+		map[string]any{
+			"type": "direct",
+			"tag":  "direct",
+		},
+		map[string]any{
+			"type": "block",
+			"tag":  "block",
+		},
+	)
+
 	return map[string]any{
 		"log": map[string]any{
 			"level": "warn",
@@ -577,7 +1030,7 @@ func buildClientConfigMap(cfg Config, c Client) map[string]any {
 					"type":   "udp",
 					"tag":    "dns-remote",
 					"server": "1.1.1.1",
-					"detour": "vless-out",
+					"detour": "proxy",
 				},
 			},
 			"final":    "dns-remote",
@@ -586,32 +1039,7 @@ func buildClientConfigMap(cfg Config, c Client) map[string]any {
 		"inbounds": []any{
 			tunInbound,
 		},
-		"outbounds": []any{
-			map[string]any{
-				"type":        "vless",
-				"tag":         "vless-out",
-				"server":      host,
-				"server_port": port,
-				"uuid":        c.UUID,
-				"tls": map[string]any{
-					"enabled":     true,
-					"server_name": cfg.TLSServerName,
-					"insecure":    cfg.ClientInsecureTLS,
-				},
-				"transport": map[string]any{
-					"type": "ws",
-					"path": cfg.WebsocketPath,
-				},
-			},
-			map[string]any{
-				"type": "direct",
-				"tag":  "direct",
-			},
-			map[string]any{
-				"type": "block",
-				"tag":  "block",
-			},
-		},
+		"outbounds": outbounds,
 		"route": map[string]any{
 			"auto_detect_interface": true,
 			"default_domain_resolver": map[string]any{
@@ -624,38 +1052,58 @@ func buildClientConfigMap(cfg Config, c Client) map[string]any {
 					"action":   "hijack-dns",
 				},
 				map[string]any{
-					"ip_cidr":  privateCIDRs,
+					"ip_cidr":  clientPrivateCIDRs,
 					"outbound": "direct",
 				},
 			},
-			"final": "vless-out",
+			"final": "proxy",
 		},
 	}
 }
 
-func buildClientShareURI(cfg Config, c Client) string {
-	query := url.Values{}
-	query.Set("encryption", "none")
-	query.Set("security", "tls")
-	query.Set("type", "ws")
-	query.Set("path", cfg.WebsocketPath)
-	if strings.TrimSpace(cfg.TLSServerName) != "" {
-		query.Set("sni", cfg.TLSServerName)
+func proxyOutbounds(cfg Config, c Client) []any {
+	inbounds := cfg.inboundConfigs()
+	if len(inbounds) == 1 {
+		inCfg := cfg.forInbound(inbounds[0])
+		return []any{protocolFor(inCfg).clientOutbound(inCfg, "proxy", c)}
 	}
-	if cfg.ClientInsecureTLS {
-		query.Set("allowInsecure", "1")
+
+	tags := make([]string, 0, len(inbounds))
+	members := make([]any, 0, len(inbounds))
+	for _, in := range inbounds {
+		tag := "proxy-" + in.Tag
+		tags = append(tags, tag)
+		inCfg := cfg.forInbound(in)
+		members = append(members, protocolFor(inCfg).clientOutbound(inCfg, tag, c))
 	}
 
-	host, port := resolveEndpointHostPort(cfg.EndpointHost, cfg.ListenPort)
+	selector := map[string]any{
+		"type":      "urltest",
+		"tag":       "proxy",
+		"outbounds": tags,
+		"url":       "https://www.gstatic.com/generate_204",
+		"interval":  "3m",
+	}
+	return append([]any{selector}, members...)
+}
 
-	uri := url.URL{
-		Scheme:   "vless",
-		User:     url.User(c.UUID),
-		Host:     net.JoinHostPort(host, strconv.Itoa(port)),
-		RawQuery: query.Encode(),
-		Fragment: c.Name,
+func buildClientShareURI(cfg Config, c Client) string {
+	inCfg := cfg.forInbound(cfg.inboundConfigs()[0])
+	return protocolFor(inCfg).shareURI(inCfg, c, c.Name)
+}
+
+func buildClientShareURIs(cfg Config, c Client) []string {
+	inbounds := cfg.inboundConfigs()
+	uris := make([]string, 0, len(inbounds))
+	for i, in := range inbounds {
+		inCfg := cfg.forInbound(in)
+		fragment := c.Name
+		if i > 0 {
+			fragment = fmt.Sprintf("%s (%s)", c.Name, inCfg.inboundLabel())
+		}
+		uris = append(uris, protocolFor(inCfg).shareURI(inCfg, c, fragment))
 	}
-	return uri.String()
+	return uris
 }
 
 func resolveEndpointHostPort(rawHost string, fallbackPort int) (string, int) {
@@ -721,6 +1169,41 @@ func sanitizeClientID(name string) string {
 	return n
 }
 
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]struct{}, len(tags))
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		trimmed := strings.TrimSpace(tag)
+		if trimmed == "" {
+			continue
+		}
+		if _, dup := seen[trimmed]; dup {
+			continue
+		}
+		seen[trimmed] = struct{}{}
+		out = append(out, trimmed)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func normalizeLabels(labels map[string]string) map[string]string {
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		key := strings.TrimSpace(k)
+		if key == "" {
+			continue
+		}
+		out[key] = strings.TrimSpace(v)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
 func splitAndTrimCSV(raw string) []string {
 	parts := strings.Split(raw, ",")
 	out := make([]string, 0, len(parts))
@@ -750,63 +1233,45 @@ func generateUUID() (string, error) {
 	), nil
 }
 
-func generateSelfSignedCertificate(certPath, keyPath, commonName string) error {
-	if err := os.MkdirAll(filepath.Dir(certPath), 0o700); err != nil {
-		return err
-	}
-	if err := os.MkdirAll(filepath.Dir(keyPath), 0o700); err != nil {
-		return err
-	}
-
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return err
-	}
-
-	serialLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serial, err := rand.Int(rand.Reader, serialLimit)
-	if err != nil {
-		return err
+func fillClientCredentials(c *Client, shadowsocksMethod string) (bool, error) {
+	changed := false
+	if strings.TrimSpace(c.UUID) == "" {
+		u, err := generateUUID()
+		if err != nil {
+			return false, err
+		}
+		c.UUID = u
+		changed = true
 	}
-
-	notBefore := time.Now().Add(-1 * time.Hour)
-	notAfter := notBefore.Add(365 * 24 * time.Hour)
-
-	tpl := &x509.Certificate{
-		SerialNumber: serial,
-		Subject: pkix.Name{
-			CommonName: commonName,
-		},
-		NotBefore:             notBefore,
-		NotAfter:              notAfter,
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		BasicConstraintsValid: true,
+	if strings.TrimSpace(c.Address) == "" {
+		c.Address = c.UUID
+		changed = true
 	}
-
-	if ip := net.ParseIP(commonName); ip != nil {
-		tpl.IPAddresses = append(tpl.IPAddresses, ip)
-	} else {
-		tpl.DNSNames = append(tpl.DNSNames, commonName)
+	if strings.TrimSpace(c.Password) == "" {
+		password, err := generatePassword()
+		if err != nil {
+			return false, err
+		}
+		c.Password = password
+		changed = true
 	}
-	tpl.DNSNames = append(tpl.DNSNames, "localhost")
-	tpl.IPAddresses = append(tpl.IPAddresses, net.ParseIP("127.0.0.1"))
-
-	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &privateKey.PublicKey, privateKey)
-	if err != nil {
-		return err
+	if !validShadowsocksKey(c.ShadowsocksKey, shadowsocksMethod) {
+		key, err := generateShadowsocksKey(shadowsocksMethod)
+		if err != nil {
+			return false, err
+		}
+		c.ShadowsocksKey = key
+		changed = true
 	}
+	return changed, nil
+}
 
-	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
-	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
-
-	if err := writeSecretFile(certPath, certPEM); err != nil {
-		return err
-	}
-	if err := writeSecretFile(keyPath, keyPEM); err != nil {
-		return err
+func generatePassword() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
-	return nil
+	return hex.EncodeToString(b), nil
 }
 
 func marshalPretty(v any) ([]byte, error) {
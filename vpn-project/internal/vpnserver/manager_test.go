@@ -1,6 +1,48 @@
 package vpnserver
 
-import "testing"
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	stateDir := t.TempDir()
+	cfg := Config{
+		StateDir:      stateDir,
+		Interface:     "vless",
+		ListenAddress: "::",
+		ListenPort:    8443,
+		EndpointHost:  "vpn.example.com",
+		WebsocketPath: "/vpn",
+		TLSServerName: "vpn.example.com",
+		TLSCertPath:   filepath.Join(stateDir, "tls", "server.crt"),
+		TLSKeyPath:    filepath.Join(stateDir, "tls", "server.key"),
+		ClientTunName: "sb-tun",
+		ClientTunCIDR: "172.19.0.1/30",
+		SingBoxBinary: filepath.Join(stateDir, "missing-sing-box"),
+	}
+	mgr := NewManager(cfg, log.New(io.Discard, "", 0))
+	if err := mgr.InitState(); err != nil {
+		t.Fatalf("init state: %v", err)
+	}
+	return mgr
+}
+
+func readServerConfig(t *testing.T, mgr *Manager) string {
+	t.Helper()
+	raw, err := os.ReadFile(mgr.serverConfigPath)
+	if err != nil {
+		t.Fatalf("read server config: %v", err)
+	}
+	return string(raw)
+}
 
 func TestResolveEndpointHostPort_EmptyHostUsesFallback(t *testing.T) {
 	host, port := resolveEndpointHostPort("", 443)
@@ -88,7 +130,7 @@ func TestBuildClientConfigMap_UsesTunAddressList(t *testing.T) {
 	if firstServer["server"] != "1.1.1.1" {
 		t.Fatalf("first dns server address mismatch: %#v", firstServer["server"])
 	}
-	if firstServer["detour"] != "vless-out" {
+	if firstServer["detour"] != "proxy" {
 		t.Fatalf("dns remote detour mismatch: %#v", firstServer["detour"])
 	}
 
@@ -169,3 +211,266 @@ func TestSplitAndTrimCSV(t *testing.T) {
 		t.Fatalf("unexpected parsed values: %#v", values)
 	}
 }
+
+func TestDeleteClient_RemovesUserAndConfig(t *testing.T) {
+	mgr := newTestManager(t)
+
+	c, _, err := mgr.CreateClient("alice", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	if !strings.Contains(readServerConfig(t, mgr), c.UUID) {
+		t.Fatalf("server config must contain new client uuid")
+	}
+
+	deleted, err := mgr.DeleteClient(c.ID)
+	if err != nil {
+		t.Fatalf("delete client: %v", err)
+	}
+	if deleted.ID != c.ID {
+		t.Fatalf("got deleted id %q, want %q", deleted.ID, c.ID)
+	}
+	if strings.Contains(readServerConfig(t, mgr), c.UUID) {
+		t.Fatalf("server config must not contain deleted client uuid")
+	}
+	if _, err := os.Stat(c.ConfigPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("client config must be removed, stat err: %v", err)
+	}
+	if _, _, err := mgr.GetClientConfig(c.ID); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got err %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestDeleteClient_UnknownClient(t *testing.T) {
+	mgr := newTestManager(t)
+	if _, err := mgr.DeleteClient("missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got err %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestBuildServerConfigMap_SkipsDisabledClients(t *testing.T) {
+	cfg := Config{ListenPort: 443, WebsocketPath: "/vpn"}
+	clients := []Client{
+		{ID: "active", Name: "active", UUID: "11111111-1111-1111-1111-111111111111"},
+		{ID: "paused", Name: "paused", UUID: "22222222-2222-2222-2222-222222222222", Disabled: true},
+	}
+
+	built := buildServerConfigMap(cfg, clients)
+	inbounds, ok := built["inbounds"].([]any)
+	if !ok || len(inbounds) != 1 {
+		t.Fatalf("inbounds is missing or invalid: %#v", built["inbounds"])
+	}
+	inbound, ok := inbounds[0].(map[string]any)
+	if !ok {
+		t.Fatalf("inbound has unexpected type: %T", inbounds[0])
+	}
+	users, ok := inbound["users"].([]map[string]string)
+	if !ok {
+		t.Fatalf("users has unexpected type: %T", inbound["users"])
+	}
+	if len(users) != 1 || users[0]["name"] != "active" {
+		t.Fatalf("unexpected users: %#v", users)
+	}
+}
+
+func TestSetClientDisabled_KeepsUUID(t *testing.T) {
+	mgr := newTestManager(t)
+
+	c, _, err := mgr.CreateClient("contractor", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	disabled, err := mgr.SetClientDisabled(c.ID, true)
+	if err != nil {
+		t.Fatalf("disable client: %v", err)
+	}
+	if !disabled.Disabled {
+		t.Fatalf("client must be disabled")
+	}
+	if strings.Contains(readServerConfig(t, mgr), c.UUID) {
+		t.Fatalf("server config must not contain disabled client uuid")
+	}
+
+	enabled, err := mgr.SetClientDisabled(c.ID, false)
+	if err != nil {
+		t.Fatalf("enable client: %v", err)
+	}
+	if enabled.UUID != c.UUID {
+		t.Fatalf("got uuid %q, want %q", enabled.UUID, c.UUID)
+	}
+	if !strings.Contains(readServerConfig(t, mgr), c.UUID) {
+		t.Fatalf("server config must contain re-enabled client uuid")
+	}
+}
+
+func TestEnforceExpiry_DropsExpiredClient(t *testing.T) {
+	mgr := newTestManager(t)
+
+	expiresAt := time.Now().Add(time.Hour)
+	c, _, err := mgr.CreateClient("trial", &expiresAt)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	// A check that finds nothing expired must not rewrite any config.
+	if err := os.WriteFile(c.ConfigPath, []byte("untouched"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := mgr.EnforceExpiry(); err != nil || changed {
+		t.Fatalf("got changed=%v err=%v, want no change", changed, err)
+	}
+	if raw, _ := os.ReadFile(c.ConfigPath); string(raw) != "untouched" {
+		t.Fatalf("expiry check without expired clients must not rewrite client configs")
+	}
+
+	mgr.mu.Lock()
+	clients, err := mgr.loadClientsLocked()
+	if err != nil {
+		mgr.mu.Unlock()
+		t.Fatalf("load clients: %v", err)
+	}
+	past := time.Now().Add(-time.Minute).UTC()
+	trial := clients[c.ID]
+	trial.ExpiresAt = &past
+	clients[c.ID] = trial
+	if err := mgr.saveClientsLocked(clients); err != nil {
+		mgr.mu.Unlock()
+		t.Fatalf("save clients: %v", err)
+	}
+	mgr.mu.Unlock()
+
+	changed, err := mgr.EnforceExpiry()
+	if err != nil {
+		t.Fatalf("enforce expiry: %v", err)
+	}
+	if !changed {
+		t.Fatalf("expiry check must update server config")
+	}
+	if strings.Contains(readServerConfig(t, mgr), c.UUID) {
+		t.Fatalf("server config must not contain expired client uuid")
+	}
+
+	status, err := mgr.GetStatus()
+	if err != nil {
+		t.Fatalf("get status: %v", err)
+	}
+	for _, sc := range status.Clients {
+		if sc.ID == c.ID && !sc.Expired {
+			t.Fatalf("status must report client as expired")
+		}
+	}
+}
+
+func TestRotateClientCredentials_ReplacesCredentials(t *testing.T) {
+	mgr := newTestManager(t)
+
+	c, _, err := mgr.CreateClient("leaky", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	rotated, profile, err := mgr.RotateClientCredentials(c.ID)
+	if err != nil {
+		t.Fatalf("rotate client: %v", err)
+	}
+	if rotated.ID != c.ID || rotated.Name != c.Name {
+		t.Fatalf("rotation must keep id and name, got %q/%q", rotated.ID, rotated.Name)
+	}
+	if rotated.UUID == c.UUID {
+		t.Fatalf("rotation must issue a new uuid")
+	}
+	if rotated.Password == c.Password || rotated.ShadowsocksKey == c.ShadowsocksKey {
+		t.Fatalf("rotation must issue a new password and shadowsocks key")
+	}
+	if !strings.Contains(profile.Config, rotated.UUID) {
+		t.Fatalf("client config must contain the new uuid")
+	}
+
+	serverConfig := readServerConfig(t, mgr)
+	if strings.Contains(serverConfig, c.UUID) {
+		t.Fatalf("server config must not contain the old uuid")
+	}
+	if !strings.Contains(serverConfig, rotated.UUID) {
+		t.Fatalf("server config must contain the new uuid")
+	}
+}
+
+func TestUpdateClient_RenameFollowsShareURIAndServerUsers(t *testing.T) {
+	mgr := newTestManager(t)
+
+	c, _, err := mgr.CreateClient("laptop", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	name := "Alice MacBook"
+	tags := []string{" team-a ", "", "team-a", "vip"}
+	labels := map[string]string{"plan": " pro ", " ": "ignored"}
+	updated, err := mgr.UpdateClient(c.ID, ClientUpdate{Name: &name, Tags: &tags, Labels: &labels})
+	if err != nil {
+		t.Fatalf("update client: %v", err)
+	}
+	if updated.ID != c.ID || updated.UUID != c.UUID {
+		t.Fatalf("update must keep id and uuid")
+	}
+	if len(updated.Tags) != 2 || updated.Tags[0] != "team-a" || updated.Tags[1] != "vip" {
+		t.Fatalf("unexpected tags: %#v", updated.Tags)
+	}
+	if len(updated.Labels) != 1 || updated.Labels["plan"] != "pro" {
+		t.Fatalf("unexpected labels: %#v", updated.Labels)
+	}
+
+	if !strings.Contains(readServerConfig(t, mgr), `"name": "Alice MacBook"`) {
+		t.Fatalf("server users must follow the rename")
+	}
+	if uri := buildClientShareURI(mgr.cfg, updated); !strings.HasSuffix(uri, "#Alice%20MacBook") {
+		t.Fatalf("share uri fragment must follow the rename: %s", uri)
+	}
+
+	stored, err := mgr.GetClient(c.ID)
+	if err != nil {
+		t.Fatalf("get client: %v", err)
+	}
+	if stored.Name != name || len(stored.Tags) != 2 {
+		t.Fatalf("update must be persisted: %#v", stored)
+	}
+}
+
+func TestSelfSignedCertificate_IsPinnedInClientConfigAndShareURI(t *testing.T) {
+	mgr := newTestManager(t)
+	if mgr.cfg.PinnedCertSHA256 != "" {
+		t.Fatalf("pinning must be opt-in via ClientPinCert in tests")
+	}
+
+	mgr.cfg.ClientPinCert = true
+	mgr.cfg.ClientInsecureTLS = true
+	if err := mgr.refreshCertPinLocked(); err != nil {
+		t.Fatalf("refresh pin: %v", err)
+	}
+	if len(mgr.cfg.PinnedCertSHA256) != 64 {
+		t.Fatalf("unexpected pin %q", mgr.cfg.PinnedCertSHA256)
+	}
+
+	c := Client{Name: "alice", UUID: "11111111-1111-1111-1111-111111111111"}
+	outbound := buildClientConfigMap(mgr.cfg, c)["outbounds"].([]any)[0].(map[string]any)
+	tls := outbound["tls"].(map[string]any)
+	if _, ok := tls["insecure"]; ok {
+		t.Fatalf("pinned client must not skip verification: %#v", tls)
+	}
+	if !strings.Contains(tls["certificate"].(string), "BEGIN CERTIFICATE") {
+		t.Fatalf("pinned client must embed the server certificate: %#v", tls)
+	}
+
+	uri := buildClientShareURI(mgr.cfg, c)
+	if !strings.Contains(uri, "pcs="+mgr.cfg.PinnedCertSHA256) || strings.Contains(uri, "allowInsecure") {
+		t.Fatalf("share uri must carry the pin instead of allowInsecure: %s", uri)
+	}
+
+	mgr.cfg.TLSMode = TLSModeACME
+	if err := mgr.refreshCertPinLocked(); err != nil {
+		t.Fatalf("refresh pin: %v", err)
+	}
+	if mgr.cfg.PinnedCertPEM != "" {
+		t.Fatalf("acme certificates must not be pinned")
+	}
+}
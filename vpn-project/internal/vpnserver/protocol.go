@@ -0,0 +1,292 @@
+package vpnserver
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type protocol interface {
+	serverInbound(cfg Config, tag string, clients []Client) map[string]any
+	clientOutbound(cfg Config, tag string, c Client) map[string]any
+	shareURI(cfg Config, c Client, fragment string) string
+	clashProxy(cfg Config, name string, c Client) map[string]any
+	// xrayOutbound returns nil for protocols Xray cannot dial.
+	xrayOutbound(cfg Config, tag string, c Client) map[string]any
+	supportsReality() bool
+}
+
+var protocols = map[string]protocol{
+	ProtocolVLESS:       vlessProtocol{},
+	ProtocolTrojan:      trojanProtocol{},
+	ProtocolShadowsocks: shadowsocksProtocol{},
+	ProtocolHysteria2:   hysteria2Protocol{},
+}
+
+func protocolFor(cfg Config) protocol {
+	return protocols[cfg.protocolType()]
+}
+
+type vlessProtocol struct{}
+
+func (vlessProtocol) supportsReality() bool { return true }
+
+func (vlessProtocol) serverInbound(cfg Config, tag string, clients []Client) map[string]any {
+	flow := cfg.vlessFlow()
+	users := make([]map[string]string, 0, len(clients))
+	for _, c := range clients {
+		user := map[string]string{
+			"name": c.serverUserName(),
+			"uuid": c.UUID,
+		}
+		if flow != "" {
+			user["flow"] = flow
+		}
+		users = append(users, user)
+	}
+
+	inbound := map[string]any{
+		"type":        "vless",
+		"tag":         tag,
+		"listen":      cfg.ListenAddress,
+		"listen_port": cfg.ListenPort,
+		"users":       users,
+		"tls":         serverTLS(cfg),
+	}
+	if transport := transportOptions(cfg); transport != nil {
+		inbound["transport"] = transport
+	}
+	return inbound
+}
+
+func (vlessProtocol) clientOutbound(cfg Config, tag string, c Client) map[string]any {
+	host, port := resolveEndpointHostPort(cfg.EndpointHost, cfg.ListenPort)
+	outbound := map[string]any{
+		"type":        "vless",
+		"tag":         tag,
+		"server":      host,
+		"server_port": port,
+		"uuid":        c.UUID,
+		"tls":         clientTLS(cfg),
+	}
+	if flow := cfg.vlessFlow(); flow != "" {
+		outbound["flow"] = flow
+	}
+	if transport := transportOptions(cfg); transport != nil {
+		outbound["transport"] = transport
+	}
+	return outbound
+}
+
+func (vlessProtocol) shareURI(cfg Config, c Client, fragment string) string {
+	query := url.Values{}
+	query.Set("encryption", "none")
+	setSecurityShareParams(query, cfg)
+	setTransportShareParams(query, cfg)
+	if flow := cfg.vlessFlow(); flow != "" {
+		query.Set("flow", flow)
+	}
+	return shareURL("vless", url.User(c.UUID), cfg, query, fragment)
+}
+
+type trojanProtocol struct{}
+
+func (trojanProtocol) supportsReality() bool { return true }
+
+func (trojanProtocol) serverInbound(cfg Config, tag string, clients []Client) map[string]any {
+	inbound := map[string]any{
+		"type":        "trojan",
+		"tag":         tag,
+		"listen":      cfg.ListenAddress,
+		"listen_port": cfg.ListenPort,
+		"users":       passwordUsers(clients),
+		"tls":         serverTLS(cfg),
+	}
+	if transport := transportOptions(cfg); transport != nil {
+		inbound["transport"] = transport
+	}
+	return inbound
+}
+
+func (trojanProtocol) clientOutbound(cfg Config, tag string, c Client) map[string]any {
+	host, port := resolveEndpointHostPort(cfg.EndpointHost, cfg.ListenPort)
+	outbound := map[string]any{
+		"type":        "trojan",
+		"tag":         tag,
+		"server":      host,
+		"server_port": port,
+		"password":    c.Password,
+		"tls":         clientTLS(cfg),
+	}
+	if transport := transportOptions(cfg); transport != nil {
+		outbound["transport"] = transport
+	}
+	return outbound
+}
+
+func (trojanProtocol) shareURI(cfg Config, c Client, fragment string) string {
+	query := url.Values{}
+	setSecurityShareParams(query, cfg)
+	setTransportShareParams(query, cfg)
+	return shareURL("trojan", url.User(c.Password), cfg, query, fragment)
+}
+
+type shadowsocksProtocol struct{}
+
+func (shadowsocksProtocol) supportsReality() bool { return false }
+
+func (shadowsocksProtocol) serverInbound(cfg Config, tag string, clients []Client) map[string]any {
+	users := make([]map[string]string, 0, len(clients))
+	for _, c := range clients {
+		users = append(users, map[string]string{
+			"name":     c.serverUserName(),
+			"password": c.ShadowsocksKey,
+		})
+	}
+	return map[string]any{
+		"type":        "shadowsocks",
+		"tag":         tag,
+		"listen":      cfg.ListenAddress,
+		"listen_port": cfg.ListenPort,
+		"method":      cfg.ShadowsocksMethod,
+		"password":    cfg.ShadowsocksServerKey,
+		"users":       users,
+	}
+}
+
+func (shadowsocksProtocol) clientOutbound(cfg Config, tag string, c Client) map[string]any {
+	host, port := resolveEndpointHostPort(cfg.EndpointHost, cfg.ListenPort)
+	return map[string]any{
+		"type":        "shadowsocks",
+		"tag":         tag,
+		"server":      host,
+		"server_port": port,
+		"method":      cfg.ShadowsocksMethod,
+		"password":    shadowsocksClientPassword(cfg, c),
+	}
+}
+
+// SIP022 links carry the method and "server_key:user_key" as plain
+// percent-encoded userinfo rather than the legacy base64 form.
+func (shadowsocksProtocol) shareURI(cfg Config, c Client, fragment string) string {
+	user := url.UserPassword(cfg.ShadowsocksMethod, shadowsocksClientPassword(cfg, c))
+	return shareURL("ss", user, cfg, nil, fragment)
+}
+
+func shadowsocksClientPassword(cfg Config, c Client) string {
+	return cfg.ShadowsocksServerKey + ":" + c.ShadowsocksKey
+}
+
+type hysteria2Protocol struct{}
+
+func (hysteria2Protocol) supportsReality() bool { return false }
+
+func (hysteria2Protocol) serverInbound(cfg Config, tag string, clients []Client) map[string]any {
+	tls := serverTLS(cfg)
+	tls["alpn"] = []string{"h3"}
+	return map[string]any{
+		"type":        "hysteria2",
+		"tag":         tag,
+		"listen":      cfg.ListenAddress,
+		"listen_port": cfg.ListenPort,
+		"users":       passwordUsers(clients),
+		"tls":         tls,
+	}
+}
+
+func (hysteria2Protocol) clientOutbound(cfg Config, tag string, c Client) map[string]any {
+	host, port := resolveEndpointHostPort(cfg.EndpointHost, cfg.ListenPort)
+	tls := clientTLS(cfg)
+	tls["alpn"] = []string{"h3"}
+	return map[string]any{
+		"type":        "hysteria2",
+		"tag":         tag,
+		"server":      host,
+		"server_port": port,
+		"password":    c.Password,
+		"tls":         tls,
+	}
+}
+
+func (hysteria2Protocol) shareURI(cfg Config, c Client, fragment string) string {
+	query := url.Values{}
+	if strings.TrimSpace(cfg.TLSServerName) != "" {
+		query.Set("sni", cfg.TLSServerName)
+	}
+	if cfg.PinnedCertSHA256 != "" {
+		query.Set("pinSHA256", cfg.PinnedCertSHA256)
+	} else if cfg.ClientInsecureTLS {
+		query.Set("insecure", "1")
+	}
+	return shareURL("hysteria2", url.User(c.Password), cfg, query, fragment)
+}
+
+func passwordUsers(clients []Client) []map[string]string {
+	users := make([]map[string]string, 0, len(clients))
+	for _, c := range clients {
+		users = append(users, map[string]string{
+			"name":     c.serverUserName(),
+			"password": c.Password,
+		})
+	}
+	return users
+}
+
+func serverTLS(cfg Config) map[string]any {
+	if cfg.securityType() == SecurityReality {
+		return realityServerTLS(cfg)
+	}
+	return map[string]any{
+		"enabled":          true,
+		"server_name":      cfg.TLSServerName,
+		"certificate_path": cfg.TLSCertPath,
+		"key_path":         cfg.TLSKeyPath,
+	}
+}
+
+func clientTLS(cfg Config) map[string]any {
+	if cfg.securityType() == SecurityReality {
+		return realityClientTLS(cfg)
+	}
+	if cfg.PinnedCertPEM != "" {
+		return map[string]any{
+			"enabled":     true,
+			"server_name": cfg.TLSServerName,
+			"certificate": cfg.PinnedCertPEM,
+		}
+	}
+	return map[string]any{
+		"enabled":     true,
+		"server_name": cfg.TLSServerName,
+		"insecure":    cfg.ClientInsecureTLS,
+	}
+}
+
+func setSecurityShareParams(query url.Values, cfg Config) {
+	if cfg.securityType() == SecurityReality {
+		setRealityShareParams(query, cfg)
+		return
+	}
+	query.Set("security", "tls")
+	if strings.TrimSpace(cfg.TLSServerName) != "" {
+		query.Set("sni", cfg.TLSServerName)
+	}
+	if cfg.PinnedCertSHA256 != "" {
+		query.Set("pcs", cfg.PinnedCertSHA256)
+	} else if cfg.ClientInsecureTLS {
+		query.Set("allowInsecure", "1")
+	}
+}
+
+func shareURL(scheme string, user *url.Userinfo, cfg Config, query url.Values, fragment string) string {
+	host, port := resolveEndpointHostPort(cfg.EndpointHost, cfg.ListenPort)
+	uri := url.URL{
+		Scheme:   scheme,
+		User:     user,
+		Host:     net.JoinHostPort(host, strconv.Itoa(port)),
+		RawQuery: query.Encode(),
+		Fragment: fragment,
+	}
+	return uri.String()
+}
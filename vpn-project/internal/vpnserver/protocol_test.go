@@ -0,0 +1,137 @@
+package vpnserver
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProtocols_ServerClientAndShareURI(t *testing.T) {
+	base := Config{
+		EndpointHost:         "vpn.example.com",
+		ListenPort:           443,
+		WebsocketPath:        "/vpn",
+		TLSServerName:        "vpn.example.com",
+		ShadowsocksMethod:    defaultShadowsocksMethod,
+		ShadowsocksServerKey: "c2VydmVyLWtleS0xNi1ieQ==",
+	}
+	client := Client{
+		Name:           "alice",
+		UUID:           "11111111-1111-1111-1111-111111111111",
+		Password:       "secret-password",
+		ShadowsocksKey: "dXNlci1rZXktMTYtYnl0ZQ==",
+	}
+
+	cases := []struct {
+		protocol   string
+		scheme     string
+		credential string
+		userField  string
+	}{
+		{ProtocolTrojan, "trojan", client.Password, "password"},
+		{ProtocolShadowsocks, "ss", shadowsocksClientPassword(base, client), "password"},
+		{ProtocolHysteria2, "hysteria2", client.Password, "password"},
+	}
+	for _, tc := range cases {
+		cfg := base
+		cfg.Protocol = tc.protocol
+
+		server := buildServerConfigMap(cfg, []Client{client})
+		inbound := server["inbounds"].([]any)[0].(map[string]any)
+		if inbound["type"] != tc.protocol || inbound["tag"] != tc.protocol+"-in" {
+			t.Fatalf("%s: unexpected server inbound %#v", tc.protocol, inbound)
+		}
+		users := inbound["users"].([]map[string]string)
+		if len(users) != 1 || users[0][tc.userField] == "" {
+			t.Fatalf("%s: unexpected users %#v", tc.protocol, users)
+		}
+
+		outbound := buildClientConfigMap(cfg, client)["outbounds"].([]any)[0].(map[string]any)
+		if outbound["type"] != tc.protocol || outbound["password"] != tc.credential {
+			t.Fatalf("%s: unexpected client outbound %#v", tc.protocol, outbound)
+		}
+
+		parsed, err := url.Parse(buildClientShareURI(cfg, client))
+		if err != nil {
+			t.Fatalf("%s: parse share uri: %v", tc.protocol, err)
+		}
+		secret := parsed.User.Username()
+		if p, ok := parsed.User.Password(); ok {
+			secret = p
+		}
+		if parsed.Scheme != tc.scheme || secret != tc.credential || parsed.Port() != "443" {
+			t.Fatalf("%s: unexpected share uri %s", tc.protocol, parsed)
+		}
+	}
+
+	cfg := base
+	cfg.Protocol = ProtocolShadowsocks
+	inbound := buildServerConfigMap(cfg, []Client{client})["inbounds"].([]any)[0].(map[string]any)
+	if inbound["method"] != defaultShadowsocksMethod || inbound["password"] != base.ShadowsocksServerKey {
+		t.Fatalf("shadowsocks inbound must carry method and server key: %#v", inbound)
+	}
+	if _, ok := inbound["tls"]; ok {
+		t.Fatalf("shadowsocks inbound must not enable tls")
+	}
+}
+
+func TestProtocol_RealityFallsBackToTLSForHysteria2(t *testing.T) {
+	cfg := Config{Protocol: ProtocolHysteria2, Security: SecurityReality}
+	if cfg.securityType() != SecurityTLS {
+		t.Fatalf("hysteria2 must not use reality")
+	}
+	got, _ := parseExtraInbounds("protocol=hy2,port=443,security=reality")
+	if len(got) != 1 || got[0].Protocol != ProtocolHysteria2 || got[0].Security != SecurityTLS || got[0].Tag != "hysteria2-443" {
+		t.Fatalf("unexpected hysteria2 inbound: %#v", got)
+	}
+}
+
+func TestShadowsocksKeys_GeneratedAndPersisted(t *testing.T) {
+	mgr := newTestManager(t)
+
+	c, _, err := mgr.CreateClient("alice", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	if c.Password == "" || !validShadowsocksKey(c.ShadowsocksKey, defaultShadowsocksMethod) {
+		t.Fatalf("new clients must receive trojan/hysteria2 and shadowsocks credentials: %#v", c)
+	}
+
+	mgr.cfg.Protocol = ProtocolShadowsocks
+	if err := mgr.ensureShadowsocksMaterialLocked(); err != nil {
+		t.Fatalf("ensure shadowsocks material: %v", err)
+	}
+	serverKey := mgr.cfg.ShadowsocksServerKey
+	if !validShadowsocksKey(serverKey, defaultShadowsocksMethod) {
+		t.Fatalf("invalid generated server key %q", serverKey)
+	}
+	raw, err := os.ReadFile(filepath.Join(mgr.cfg.StateDir, "shadowsocks.json"))
+	if err != nil || !strings.Contains(string(raw), serverKey) {
+		t.Fatalf("server key must be persisted: %v", err)
+	}
+
+	mgr.cfg.ShadowsocksServerKey = ""
+	if err := mgr.ensureShadowsocksMaterialLocked(); err != nil {
+		t.Fatalf("reload shadowsocks material: %v", err)
+	}
+	if mgr.cfg.ShadowsocksServerKey != serverKey {
+		t.Fatalf("server key must survive restarts")
+	}
+}
+
+func TestShadowsocksMethod_RejectsUnsupportedValues(t *testing.T) {
+	for _, method := range []string{"2022-blake3-chacha20-poly1305", "aes-256-gcm", "2022-blake3-aes-512-gcm"} {
+		mgr := newTestManager(t)
+		mgr.cfg.ShadowsocksMethod = method
+		if err := mgr.InitState(); err == nil || !strings.Contains(err.Error(), "VLESS_SS_METHOD") {
+			t.Fatalf("%s: unsupported method must be rejected at startup, got %v", method, err)
+		}
+	}
+
+	cfg := Config{ShadowsocksMethod: " 2022-BLAKE3-AES-256-GCM "}
+	if err := cfg.checkShadowsocksMethod(); err != nil {
+		t.Fatalf("supported method must be accepted: %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package vpnserver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	QuotaPeriodMonthly = "monthly"
+	QuotaPeriodTotal   = "total"
+)
+
+// ErrStatsAPIDisabled rejects quotas that could never be enforced: without
+// VLESS_STATS_API no traffic is counted.
+var ErrStatsAPIDisabled = errors.New("quotas require VLESS_STATS_API")
+
+type ClientQuota struct {
+	Bytes    int64  `json:"bytes"`
+	Period   string `json:"period"`
+	ResetDay int    `json:"reset_day,omitempty"`
+}
+
+type QuotaStatus struct {
+	Period         string     `json:"period"`
+	LimitBytes     int64      `json:"limit_bytes"`
+	UsedBytes      int64      `json:"used_bytes"`
+	RemainingBytes int64      `json:"remaining_bytes"`
+	Exceeded       bool       `json:"exceeded"`
+	ExceededAt     *time.Time `json:"exceeded_at,omitempty"`
+	ResetsAt       *time.Time `json:"resets_at,omitempty"`
+}
+
+// normalizeQuota validates a quota from the API; zero bytes removes it.
+func normalizeQuota(q ClientQuota) (*ClientQuota, error) {
+	if q.Bytes < 0 {
+		return nil, fmt.Errorf("%w: quota bytes must not be negative", errInvalidClient)
+	}
+	if q.Bytes == 0 {
+		return nil, nil
+	}
+
+	q.Period = strings.ToLower(strings.TrimSpace(q.Period))
+	switch q.Period {
+	case "", QuotaPeriodMonthly:
+		q.Period = QuotaPeriodMonthly
+		if q.ResetDay == 0 {
+			q.ResetDay = 1
+		}
+		if q.ResetDay < 1 || q.ResetDay > 28 {
+			return nil, fmt.Errorf("%w: quota reset_day must be between 1 and 28", errInvalidClient)
+		}
+	case QuotaPeriodTotal:
+		q.ResetDay = 0
+	default:
+		return nil, fmt.Errorf("%w: quota period must be %q or %q", errInvalidClient, QuotaPeriodMonthly, QuotaPeriodTotal)
+	}
+	return &q, nil
+}
+
+func quotaPeriodStart(now time.Time, resetDay int) time.Time {
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), resetDay, 0, 0, 0, 0, time.UTC)
+	if now.Before(start) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start
+}
+
+func quotaUsedBytes(q *ClientQuota, t ClientTraffic) int64 {
+	if q.Period == QuotaPeriodTotal {
+		return t.UplinkBytes + t.DownlinkBytes
+	}
+	return t.PeriodBytes
+}
+
+func clientQuotaStatus(c Client, t ClientTraffic) *QuotaStatus {
+	if c.Quota == nil {
+		return nil
+	}
+	used := quotaUsedBytes(c.Quota, t)
+	status := &QuotaStatus{
+		Period:         c.Quota.Period,
+		LimitBytes:     c.Quota.Bytes,
+		UsedBytes:      used,
+		RemainingBytes: max(c.Quota.Bytes-used, 0),
+		Exceeded:       c.QuotaExceededAt != nil,
+		ExceededAt:     c.QuotaExceededAt,
+	}
+	if c.Quota.Period == QuotaPeriodMonthly && t.PeriodStart != nil {
+		resetsAt := t.PeriodStart.AddDate(0, 1, 0)
+		status.ResetsAt = &resetsAt
+	}
+	return status
+}
+
+// rollQuotaPeriods starts a new monthly period for clients whose reset day
+// has passed. Usage is metered from the start of the period in which the
+// quota was assigned.
+func rollQuotaPeriods(clients map[string]Client, traffic map[string]ClientTraffic, now time.Time) bool {
+	changed := false
+	for id, c := range clients {
+		if c.Quota == nil || c.Quota.Period != QuotaPeriodMonthly {
+			continue
+		}
+		t := traffic[id]
+		start := quotaPeriodStart(now, c.Quota.ResetDay)
+		if t.PeriodStart == nil || t.PeriodStart.Before(start) {
+			t.PeriodBytes = 0
+			t.PeriodStart = &start
+			traffic[id] = t
+			changed = true
+		}
+	}
+	return changed
+}
+
+// markQuotasLocked sets or clears QuotaExceededAt; active() keeps flagged
+// clients out of server.json until the next period or a raised quota.
+func (m *Manager) markQuotasLocked(clients map[string]Client, traffic map[string]ClientTraffic, now time.Time) bool {
+	changed := false
+	for id, c := range clients {
+		exceeded := c.Quota != nil && quotaUsedBytes(c.Quota, traffic[id]) >= c.Quota.Bytes
+		switch {
+		case exceeded && c.QuotaExceededAt == nil:
+			c.QuotaExceededAt = &now
+			m.logger.Printf("client %s exceeded its %s quota of %d bytes", c.ID, c.Quota.Period, c.Quota.Bytes)
+		case !exceeded && c.QuotaExceededAt != nil:
+			c.QuotaExceededAt = nil
+			m.logger.Printf("client %s is back under its quota", c.ID)
+		default:
+			continue
+		}
+		clients[id] = c
+		changed = true
+	}
+	return changed
+}
+
+// enforceQuotasLocked only updates clients in memory; callers persist them
+// through commitClientsLocked.
+func (m *Manager) enforceQuotasLocked(clients map[string]Client, now time.Time) (bool, error) {
+	traffic, err := m.loadTrafficLocked()
+	if err != nil {
+		return false, err
+	}
+	if rollQuotaPeriods(clients, traffic, now) {
+		if err := m.saveTrafficLocked(traffic); err != nil {
+			return false, err
+		}
+	}
+	return m.markQuotasLocked(clients, traffic, now), nil
+}
@@ -0,0 +1,198 @@
+package vpnserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuotaPeriodStart(t *testing.T) {
+	cases := []struct {
+		now      time.Time
+		resetDay int
+		want     time.Time
+	}{
+		{time.Date(2026, 3, 15, 10, 0, 0, 0, time.UTC), 1, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 3, 15, 10, 0, 0, 0, time.UTC), 15, time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 3, 14, 23, 0, 0, 0, time.UTC), 15, time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 1, 3, 0, 0, 0, 0, time.UTC), 28, time.Date(2025, 12, 28, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range cases {
+		if got := quotaPeriodStart(tc.now, tc.resetDay); !got.Equal(tc.want) {
+			t.Fatalf("quotaPeriodStart(%s, %d) = %s, want %s", tc.now, tc.resetDay, got, tc.want)
+		}
+	}
+}
+
+func TestPollTraffic_CutsOffClientOverQuotaUntilReset(t *testing.T) {
+	mgr := newTestManager(t)
+	alice, _, err := mgr.CreateClient("alice", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	stats := newFakeStatsServer(t, map[string]int64{
+		"user>>>alice>>>traffic>>>uplink":   600,
+		"user>>>alice>>>traffic>>>downlink": 500,
+	})
+	mgr.cfg.StatsAPIListen = stats.addr()
+	if _, err := mgr.UpdateClient(alice.ID, ClientUpdate{Quota: &ClientQuota{Bytes: 1000}}); err != nil {
+		t.Fatalf("set quota: %v", err)
+	}
+	startFakeSingBox(t, mgr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := mgr.PollTraffic(ctx); err != nil {
+		t.Fatalf("poll traffic: %v", err)
+	}
+
+	c, err := mgr.GetClient(alice.ID)
+	if err != nil {
+		t.Fatalf("get client: %v", err)
+	}
+	if c.QuotaExceededAt == nil {
+		t.Fatalf("client over quota must be flagged")
+	}
+	if strings.Contains(readServerConfig(t, mgr), alice.UUID) {
+		t.Fatalf("client over quota must be removed from server users")
+	}
+
+	handler := NewHTTPHandler(mgr, log.New(io.Discard, "", 0))
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/clients/"+alice.ID, nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if body := rec.Body.String(); !strings.Contains(body, `"exceeded":true`) || !strings.Contains(body, `"used_bytes":1100`) {
+		t.Fatalf("quota usage must be reported: %s", body)
+	}
+
+	// Pretend the stored period started a month earlier: the next check is a reset.
+	mgr.mu.Lock()
+	traffic, err := mgr.loadTrafficLocked()
+	if err == nil {
+		tr := traffic[alice.ID]
+		previous := tr.PeriodStart.AddDate(0, -1, 0)
+		tr.PeriodStart = &previous
+		traffic[alice.ID] = tr
+		err = mgr.saveTrafficLocked(traffic)
+	}
+	mgr.mu.Unlock()
+	if err != nil {
+		t.Fatalf("rewind traffic period: %v", err)
+	}
+
+	if _, err := mgr.EnforceExpiry(); err != nil {
+		t.Fatalf("enforce: %v", err)
+	}
+	c, _ = mgr.GetClient(alice.ID)
+	if c.QuotaExceededAt != nil {
+		t.Fatalf("client must be re-enabled after the quota period resets")
+	}
+	if !strings.Contains(readServerConfig(t, mgr), alice.UUID) {
+		t.Fatalf("client must be back in server users after reset")
+	}
+	if tr, _ := mgr.GetClientTraffic(alice.ID); tr.PeriodBytes != 0 || tr.UplinkBytes != 600 {
+		t.Fatalf("reset must clear period usage only: %#v", tr)
+	}
+}
+
+func TestUpdateClient_RaisingQuotaRestoresClient(t *testing.T) {
+	mgr := newTestManager(t)
+	mgr.cfg.StatsAPIListen = "127.0.0.1:10085"
+	c, _, err := mgr.CreateClient("bob", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	mgr.mu.Lock()
+	err = mgr.saveTrafficLocked(map[string]ClientTraffic{c.ID: {UplinkBytes: 300, DownlinkBytes: 300}})
+	mgr.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = mgr.UpdateClient(c.ID, ClientUpdate{Quota: &ClientQuota{Bytes: 500, Period: QuotaPeriodTotal}})
+	if err != nil {
+		t.Fatalf("set quota: %v", err)
+	}
+	if c.QuotaExceededAt == nil || strings.Contains(readServerConfig(t, mgr), c.UUID) {
+		t.Fatalf("total quota below lifetime usage must cut the client off")
+	}
+
+	c, err = mgr.UpdateClient(c.ID, ClientUpdate{Quota: &ClientQuota{Bytes: 0}})
+	if err != nil {
+		t.Fatalf("remove quota: %v", err)
+	}
+	if c.Quota != nil || c.QuotaExceededAt != nil || !strings.Contains(readServerConfig(t, mgr), c.UUID) {
+		t.Fatalf("removing the quota must restore the client: %#v", c)
+	}
+}
+
+func TestUpdateClient_RejectsQuotaWithoutStatsAPI(t *testing.T) {
+	mgr := newTestManager(t)
+	c, _, err := mgr.CreateClient("carol", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	handler := NewHTTPHandler(mgr, log.New(io.Discard, "", 0))
+	req := httptest.NewRequest(http.MethodPatch, "http://localhost/clients/"+c.ID, strings.NewReader(`{"quota": {"bytes": 1000}}`))
+	req.RemoteAddr = "127.0.0.1:1234"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "VLESS_STATS_API") {
+		t.Fatalf("quota without the stats API must be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if stored, _ := mgr.GetClient(c.ID); stored.Quota != nil {
+		t.Fatalf("refused quota must not be stored: %#v", stored.Quota)
+	}
+
+	if _, err := mgr.UpdateClient(c.ID, ClientUpdate{Quota: &ClientQuota{Bytes: 0}}); err != nil {
+		t.Fatalf("removing a quota must work without the stats API: %v", err)
+	}
+}
+
+func TestNormalizeQuota_RejectsInvalidValues(t *testing.T) {
+	for _, q := range []ClientQuota{
+		{Bytes: -1},
+		{Bytes: 10, Period: "weekly"},
+		{Bytes: 10, Period: QuotaPeriodMonthly, ResetDay: 31},
+	} {
+		if _, err := normalizeQuota(q); err == nil {
+			t.Fatalf("quota %#v must be rejected", q)
+		}
+	}
+}
+
+func TestUpdateClientEndpoint_ManagerValidationErrorsAreBadRequests(t *testing.T) {
+	mgr := newTestManager(t)
+	mgr.cfg.StatsAPIListen = "127.0.0.1:10085"
+	c, _, err := mgr.CreateClient("dave", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	handler := NewHTTPHandler(mgr, log.New(io.Discard, "", 0))
+	for _, body := range []string{
+		`{"name": "  "}`,
+		`{"quota": {"bytes": 10, "period": "weekly"}}`,
+		`{"max_devices": -1}`,
+	} {
+		req := httptest.NewRequest(http.MethodPatch, "http://localhost/clients/"+c.ID, strings.NewReader(body))
+		req.RemoteAddr = "127.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: got status %d: %s", body, rec.Code, rec.Body.String())
+		}
+	}
+	if _, err := mgr.UpdateClient(c.ID, ClientUpdate{Quota: &ClientQuota{Bytes: -1}}); !errors.Is(err, errInvalidClient) {
+		t.Fatalf("manager must report invalid fields as errInvalidClient, got %v", err)
+	}
+}
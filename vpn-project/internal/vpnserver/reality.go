@@ -0,0 +1,182 @@
+package vpnserver
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type realityMaterial struct {
+	PrivateKey string   `json:"private_key"`
+	PublicKey  string   `json:"public_key"`
+	ShortIDs   []string `json:"short_ids"`
+}
+
+func (m *Manager) ensureRealityMaterialLocked() error {
+	if !m.cfg.usesSecurity(SecurityReality) {
+		return nil
+	}
+
+	path := filepath.Join(m.cfg.StateDir, "reality.json")
+	var stored realityMaterial
+	raw, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(raw, &stored); err != nil {
+			return fmt.Errorf("parse reality state: %w", err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("read reality state: %w", err)
+	}
+
+	material := stored
+	if m.cfg.RealityPrivateKey != "" {
+		material.PrivateKey = m.cfg.RealityPrivateKey
+		material.PublicKey = ""
+	}
+	if material.PrivateKey == "" {
+		material.PrivateKey, err = generateRealityPrivateKey()
+		if err != nil {
+			return fmt.Errorf("generate reality key pair: %w", err)
+		}
+		m.logger.Printf("generated REALITY x25519 key pair at %s", path)
+	}
+	if material.PublicKey == "" {
+		material.PublicKey, err = realityPublicKey(material.PrivateKey)
+		if err != nil {
+			return err
+		}
+	}
+	if len(m.cfg.RealityShortIDs) > 0 {
+		material.ShortIDs = m.cfg.RealityShortIDs
+	}
+	if len(material.ShortIDs) == 0 {
+		shortID, err := generateRealityShortID()
+		if err != nil {
+			return fmt.Errorf("generate reality short id: %w", err)
+		}
+		material.ShortIDs = []string{shortID}
+	}
+
+	if material.PrivateKey != stored.PrivateKey || material.PublicKey != stored.PublicKey ||
+		strings.Join(material.ShortIDs, ",") != strings.Join(stored.ShortIDs, ",") {
+		payload, err := marshalPretty(material)
+		if err != nil {
+			return fmt.Errorf("serialize reality state: %w", err)
+		}
+		if err := writeSecretFile(path, payload); err != nil {
+			return fmt.Errorf("write reality state: %w", err)
+		}
+	}
+
+	m.cfg.RealityPrivateKey = material.PrivateKey
+	m.cfg.RealityPublicKey = material.PublicKey
+	m.cfg.RealityShortIDs = material.ShortIDs
+	return nil
+}
+
+func realityServerTLS(cfg Config) map[string]any {
+	host, port := realityHandshakeHostPort(cfg.RealityHandshakeServer)
+	return map[string]any{
+		"enabled":     true,
+		"server_name": cfg.RealityServerName,
+		"reality": map[string]any{
+			"enabled": true,
+			"handshake": map[string]any{
+				"server":      host,
+				"server_port": port,
+			},
+			"private_key": cfg.RealityPrivateKey,
+			"short_id":    cfg.RealityShortIDs,
+		},
+	}
+}
+
+func realityClientTLS(cfg Config) map[string]any {
+	return map[string]any{
+		"enabled":     true,
+		"server_name": cfg.RealityServerName,
+		"utls": map[string]any{
+			"enabled":     true,
+			"fingerprint": cfg.RealityFingerprint,
+		},
+		"reality": map[string]any{
+			"enabled":    true,
+			"public_key": cfg.RealityPublicKey,
+			"short_id":   firstRealityShortID(cfg),
+		},
+	}
+}
+
+func setRealityShareParams(query url.Values, cfg Config) {
+	query.Set("security", "reality")
+	query.Set("sni", cfg.RealityServerName)
+	query.Set("fp", cfg.RealityFingerprint)
+	query.Set("pbk", cfg.RealityPublicKey)
+	if sid := firstRealityShortID(cfg); sid != "" {
+		query.Set("sid", sid)
+	}
+}
+
+func firstRealityShortID(cfg Config) string {
+	if len(cfg.RealityShortIDs) == 0 {
+		return ""
+	}
+	return cfg.RealityShortIDs[0]
+}
+
+func realityHandshakeHost(server string) string {
+	host, _ := realityHandshakeHostPort(server)
+	return host
+}
+
+func realityHandshakeHostPort(server string) (string, int) {
+	server = strings.TrimSpace(server)
+	host, rawPort, err := net.SplitHostPort(server)
+	if err != nil {
+		return server, 443
+	}
+	port, err := strconv.Atoi(rawPort)
+	if err != nil || port <= 0 {
+		port = 443
+	}
+	return host, port
+}
+
+func generateRealityPrivateKey() (string, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(key.Bytes()), nil
+}
+
+func realityPublicKey(privateKey string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(privateKey))
+	if err != nil {
+		return "", fmt.Errorf("decode reality private key: %w", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid reality private key: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+func generateRealityShortID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
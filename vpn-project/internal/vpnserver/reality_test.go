@@ -0,0 +1,99 @@
+package vpnserver
+
+import (
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEnsureRealityMaterial_GeneratesAndReusesKeys(t *testing.T) {
+	mgr := newTestManager(t)
+	mgr.cfg.Security = SecurityReality
+	mgr.cfg.RealityHandshakeServer = "www.example.org:443"
+	mgr.cfg.RealityServerName = "www.example.org"
+	mgr.cfg.RealityFingerprint = "chrome"
+
+	if err := mgr.InitState(); err != nil {
+		t.Fatalf("init state: %v", err)
+	}
+	if mgr.cfg.RealityPrivateKey == "" || mgr.cfg.RealityPublicKey == "" || len(mgr.cfg.RealityShortIDs) != 1 {
+		t.Fatalf("reality material was not generated: %#v", mgr.cfg)
+	}
+	derived, err := realityPublicKey(mgr.cfg.RealityPrivateKey)
+	if err != nil || derived != mgr.cfg.RealityPublicKey {
+		t.Fatalf("public key must derive from private key: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(mgr.cfg.StateDir, "reality.json")); err != nil {
+		t.Fatalf("reality state must be persisted: %v", err)
+	}
+
+	again := NewManager(mgr.cfg, log.New(io.Discard, "", 0))
+	again.cfg.RealityPrivateKey = ""
+	again.cfg.RealityPublicKey = ""
+	again.cfg.RealityShortIDs = nil
+	if err := again.InitState(); err != nil {
+		t.Fatalf("init state again: %v", err)
+	}
+	if again.cfg.RealityPrivateKey != mgr.cfg.RealityPrivateKey || again.cfg.RealityShortIDs[0] != mgr.cfg.RealityShortIDs[0] {
+		t.Fatalf("reality material must be reused across restarts")
+	}
+
+	server := readServerConfig(t, mgr)
+	if !strings.Contains(server, mgr.cfg.RealityPrivateKey) || !strings.Contains(server, "xtls-rprx-vision") {
+		t.Fatalf("server config must carry reality key and vision flow")
+	}
+	if strings.Contains(server, `"transport"`) {
+		t.Fatalf("reality inbound must not use the websocket transport")
+	}
+}
+
+func TestBuildClientShareURI_Reality(t *testing.T) {
+	cfg := Config{
+		EndpointHost:       "203.0.113.10",
+		ListenPort:         443,
+		Security:           SecurityReality,
+		RealityServerName:  "www.example.org",
+		RealityFingerprint: "chrome",
+		RealityPublicKey:   "pub-key",
+		RealityShortIDs:    []string{"0123456789abcdef"},
+	}
+	client := Client{Name: "alice", UUID: "11111111-1111-1111-1111-111111111111"}
+
+	parsed, err := url.Parse(buildClientShareURI(cfg, client))
+	if err != nil {
+		t.Fatalf("parse share uri: %v", err)
+	}
+	q := parsed.Query()
+	want := map[string]string{
+		"security": "reality",
+		"type":     "tcp",
+		"sni":      "www.example.org",
+		"fp":       "chrome",
+		"pbk":      "pub-key",
+		"sid":      "0123456789abcdef",
+		"flow":     "xtls-rprx-vision",
+	}
+	for key, value := range want {
+		if q.Get(key) != value {
+			t.Fatalf("query %s: got %q, want %q", key, q.Get(key), value)
+		}
+	}
+	if q.Has("path") {
+		t.Fatalf("reality share uri must not carry a websocket path")
+	}
+
+	built := buildClientConfigMap(cfg, client)
+	outbound := built["outbounds"].([]any)[0].(map[string]any)
+	tls := outbound["tls"].(map[string]any)
+	reality := tls["reality"].(map[string]any)
+	if reality["public_key"] != "pub-key" || reality["short_id"] != "0123456789abcdef" {
+		t.Fatalf("unexpected client reality options: %#v", reality)
+	}
+	if outbound["flow"] != "xtls-rprx-vision" {
+		t.Fatalf("client outbound must use vision flow, got %#v", outbound["flow"])
+	}
+}
@@ -0,0 +1,174 @@
+package vpnserver
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Log lines sing-box prints after SIGHUP: the first once the new instance is
+// running, the second when the new config is rejected and the old one stays.
+const (
+	singBoxStarted      = "sing-box started"
+	singBoxReloadFailed = "reload service"
+)
+
+const (
+	reloadConfirmTimeout = 10 * time.Second
+	reloadConfirmPoll    = 20 * time.Millisecond
+)
+
+type serverConfigSnapshot struct {
+	digest    [sha256.Size]byte
+	listeners string
+	// users maps server.json user names to client IDs for traffic counters.
+	users map[string]string
+}
+
+func (m *Manager) reloadInterfaceLocked() error {
+	if !m.interfaceRunningLocked() {
+		return nil
+	}
+
+	next, err := m.snapshotServerConfigLocked()
+	if err != nil {
+		return err
+	}
+	if next.digest == m.applied.digest {
+		return nil
+	}
+
+	if next.listeners != m.applied.listeners {
+		m.logger.Printf("sing-box listener settings changed, restarting")
+		m.stopInterfaceLocked()
+		return m.startInterfaceLocked()
+	}
+
+	return m.restartInboundsLocked(next)
+}
+
+// Certificate files are referenced by path, so replacing them does not change
+// the config digest; sing-box still has to be told to re-read them.
+func (m *Manager) reloadCertificateLocked() error {
+	if !m.interfaceRunningLocked() {
+		return nil
+	}
+
+	next, err := m.snapshotServerConfigLocked()
+	if err != nil {
+		return err
+	}
+	return m.restartInboundsLocked(next)
+}
+
+// restartInboundsLocked applies a new server.json with SIGHUP. This is not a
+// hot reload: sing-box has no API for changing inbound users, so it rebuilds
+// the whole instance and every open connection is closed. The signal only
+// saves respawning the process. sing-box keeps the previous instance when the
+// new config fails its own check, so the snapshot is only recorded as applied
+// once the log shows the new instance started.
+func (m *Manager) restartInboundsLocked(next serverConfigSnapshot) error {
+	if err := m.checkServerConfigLocked(m.serverConfigPath); err != nil {
+		return err
+	}
+	m.flushTrafficLocked()
+
+	logOffset := fileSize(m.serverLogPath)
+	if err := m.serverCmd.Process.Signal(syscall.SIGHUP); err != nil {
+		m.logger.Printf("sing-box SIGHUP failed, restarting process: %v", err)
+		m.stopInterfaceLocked()
+		return m.startInterfaceLocked()
+	}
+	if err := m.awaitReloadLocked(logOffset); err != nil {
+		return err
+	}
+	m.applied = next
+	m.logger.Printf("sing-box restarted its inbounds via SIGHUP, live connections were closed (pid=%d)", m.serverCmd.Process.Pid)
+	return nil
+}
+
+// awaitReloadLocked watches the sing-box log written after offset until the
+// reloaded instance reports it started, sing-box reports the reload failed,
+// or the process exits.
+func (m *Manager) awaitReloadLocked(offset int64) error {
+	deadline := time.Now().Add(reloadConfirmTimeout)
+	for {
+		output := readFileFrom(m.serverLogPath, offset)
+		if i := strings.Index(output, singBoxReloadFailed); i >= 0 {
+			line, _, _ := strings.Cut(output[i:], "\n")
+			return fmt.Errorf("sing-box kept the previous config: %s", strings.TrimSpace(line))
+		}
+		if strings.Contains(output, singBoxStarted) {
+			return nil
+		}
+		select {
+		case <-m.serverDone:
+			return errors.New("sing-box exited while reloading its config")
+		default:
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("sing-box did not confirm the reload within %s", reloadConfirmTimeout)
+		}
+		time.Sleep(reloadConfirmPoll)
+	}
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func readFileFrom(path string, offset int64) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return ""
+	}
+	raw, _ := io.ReadAll(f)
+	return string(raw)
+}
+
+func (m *Manager) snapshotServerConfigLocked() (serverConfigSnapshot, error) {
+	raw, err := os.ReadFile(m.serverConfigPath)
+	if err != nil {
+		return serverConfigSnapshot{}, fmt.Errorf("read server config: %w", err)
+	}
+	listeners, err := listenerFingerprint(raw)
+	if err != nil {
+		return serverConfigSnapshot{}, err
+	}
+	return serverConfigSnapshot{
+		digest:    sha256.Sum256(raw),
+		listeners: listeners,
+		users:     m.configUsers,
+	}, nil
+}
+
+func listenerFingerprint(raw []byte) (string, error) {
+	var parsed struct {
+		Inbounds []map[string]any `json:"inbounds"`
+	}
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return "", fmt.Errorf("parse server config: %w", err)
+	}
+	for _, inbound := range parsed.Inbounds {
+		delete(inbound, "users")
+	}
+	out, err := json.Marshal(parsed.Inbounds)
+	if err != nil {
+		return "", fmt.Errorf("serialize listener settings: %w", err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,119 @@
+package vpnserver
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListenerFingerprint_IgnoresUsers(t *testing.T) {
+	cfg := Config{ListenPort: 443, WebsocketPath: "/vpn"}
+	one, err := marshalPretty(buildServerConfigMap(cfg, []Client{{Name: "a", UUID: "1"}}))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	two, err := marshalPretty(buildServerConfigMap(cfg, []Client{{Name: "a", UUID: "1"}, {Name: "b", UUID: "2"}}))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	cfg.ListenPort = 8443
+	moved, err := marshalPretty(buildServerConfigMap(cfg, []Client{{Name: "a", UUID: "1"}}))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	fpOne, _ := listenerFingerprint(one)
+	fpTwo, _ := listenerFingerprint(two)
+	fpMoved, _ := listenerFingerprint(moved)
+	if fpOne != fpTwo {
+		t.Fatalf("user changes must not alter the listener fingerprint")
+	}
+	if fpOne == fpMoved {
+		t.Fatalf("port changes must alter the listener fingerprint")
+	}
+}
+
+func TestReloadInterface_SignalsRunningProcessOnUserChange(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake sing-box relies on POSIX signals")
+	}
+
+	mgr := newTestManager(t)
+	marker := filepath.Join(t.TempDir(), "hup")
+	script := filepath.Join(t.TempDir(), "sing-box")
+	body := "#!/bin/sh\n" + fakeSingBoxReload("echo hup >> "+marker+"; ") + "while true; do sleep 0.05; done\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatalf("write fake sing-box: %v", err)
+	}
+	mgr.cfg.SingBoxBinary = script
+
+	if err := mgr.StartInterface(); err != nil {
+		t.Fatalf("start interface: %v", err)
+	}
+	t.Cleanup(func() { _ = mgr.StopInterface() })
+
+	mgr.mu.Lock()
+	pid := mgr.serverCmd.Process.Pid
+	mgr.mu.Unlock()
+
+	// Give the shell a moment to install its trap before signalling it.
+	time.Sleep(150 * time.Millisecond)
+	if _, _, err := mgr.CreateClient("reload-me", nil); err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		raw, _ := os.ReadFile(marker)
+		if strings.Contains(string(raw), "hup") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sing-box did not receive SIGHUP")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	if mgr.serverCmd == nil || mgr.serverCmd.Process.Pid != pid {
+		t.Fatalf("user change must not respawn the sing-box process")
+	}
+}
+
+func TestReloadInterface_RejectedReloadIsNotRecordedAsApplied(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake sing-box relies on POSIX signals")
+	}
+
+	mgr := newTestManager(t)
+	script := filepath.Join(t.TempDir(), "sing-box")
+	body := "#!/bin/sh\ntrap 'echo \"ERROR reload service: decode config: bad\"' HUP\nwhile true; do sleep 0.05; done\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatalf("write fake sing-box: %v", err)
+	}
+	mgr.cfg.SingBoxBinary = script
+	if err := mgr.StartInterface(); err != nil {
+		t.Fatalf("start interface: %v", err)
+	}
+	t.Cleanup(func() { _ = mgr.StopInterface() })
+
+	mgr.mu.Lock()
+	applied := mgr.applied.digest
+	mgr.mu.Unlock()
+
+	time.Sleep(150 * time.Millisecond)
+	_, _, err := mgr.CreateClient("rejected", nil)
+	if err == nil || !strings.Contains(err.Error(), "decode config: bad") {
+		t.Fatalf("a reload sing-box rejected must fail the change, got %v", err)
+	}
+
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	if mgr.applied.digest != applied {
+		t.Fatalf("a rejected reload must not be recorded as applied")
+	}
+}
@@ -0,0 +1,116 @@
+package vpnserver
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	defaultShadowsocksMethod = "2022-blake3-aes-128-gcm"
+	// The server always lists users with per-user keys (EIH), which sing-box
+	// supports for the AES-GCM methods only.
+	unsupportedShadowsocksMethod = "2022-blake3-chacha20-poly1305"
+)
+
+type shadowsocksMaterial struct {
+	Method    string `json:"method"`
+	ServerKey string `json:"server_key"`
+}
+
+func normalizeShadowsocksMethod(raw string) string {
+	switch m := strings.ToLower(strings.TrimSpace(raw)); m {
+	case "2022-blake3-aes-128-gcm", "2022-blake3-aes-256-gcm":
+		return m
+	default:
+		return defaultShadowsocksMethod
+	}
+}
+
+// checkShadowsocksMethod refuses a VLESS_SS_METHOD that would otherwise fall
+// back to the default and silently change every Shadowsocks key.
+func (c Config) checkShadowsocksMethod() error {
+	switch m := strings.ToLower(strings.TrimSpace(c.ShadowsocksMethod)); m {
+	case "", "2022-blake3-aes-128-gcm", "2022-blake3-aes-256-gcm":
+		return nil
+	case unsupportedShadowsocksMethod:
+		return fmt.Errorf("VLESS_SS_METHOD %s is not supported: sing-box serves multiple Shadowsocks 2022 users only with 2022-blake3-aes-128-gcm or 2022-blake3-aes-256-gcm", m)
+	default:
+		return fmt.Errorf("VLESS_SS_METHOD must be 2022-blake3-aes-128-gcm or 2022-blake3-aes-256-gcm, got %q", c.ShadowsocksMethod)
+	}
+}
+
+func shadowsocksKeySize(method string) int {
+	if normalizeShadowsocksMethod(method) == "2022-blake3-aes-128-gcm" {
+		return 16
+	}
+	return 32
+}
+
+func (m *Manager) ensureShadowsocksMaterialLocked() error {
+	m.cfg.ShadowsocksMethod = normalizeShadowsocksMethod(m.cfg.ShadowsocksMethod)
+	if !m.cfg.usesProtocol(ProtocolShadowsocks) {
+		return nil
+	}
+
+	path := filepath.Join(m.cfg.StateDir, "shadowsocks.json")
+	var stored shadowsocksMaterial
+	raw, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(raw, &stored); err != nil {
+			return fmt.Errorf("parse shadowsocks state: %w", err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("read shadowsocks state: %w", err)
+	}
+
+	material := shadowsocksMaterial{Method: m.cfg.ShadowsocksMethod, ServerKey: stored.ServerKey}
+	if stored.Method != "" && stored.Method != material.Method {
+		m.logger.Printf("WARNING: VLESS_SS_METHOD changed from %s to %s: Shadowsocks keys that do not fit the new method are regenerated for the server and every client, and existing ss:// links stop working", stored.Method, material.Method)
+	}
+	if m.cfg.ShadowsocksServerKey != "" {
+		material.ServerKey = m.cfg.ShadowsocksServerKey
+	}
+	if !validShadowsocksKey(material.ServerKey, material.Method) {
+		if m.cfg.ShadowsocksServerKey != "" {
+			return fmt.Errorf("VLESS_SS_SERVER_KEY must be a base64 %d-byte key for %s", shadowsocksKeySize(material.Method), material.Method)
+		}
+		material.ServerKey, err = generateShadowsocksKey(material.Method)
+		if err != nil {
+			return fmt.Errorf("generate shadowsocks server key: %w", err)
+		}
+		m.logger.Printf("generated Shadowsocks 2022 server key at %s", path)
+	}
+
+	if material != stored {
+		payload, err := marshalPretty(material)
+		if err != nil {
+			return fmt.Errorf("serialize shadowsocks state: %w", err)
+		}
+		if err := writeSecretFile(path, payload); err != nil {
+			return fmt.Errorf("write shadowsocks state: %w", err)
+		}
+	}
+
+	m.cfg.ShadowsocksServerKey = material.ServerKey
+	return nil
+}
+
+func generateShadowsocksKey(method string) (string, error) {
+	b := make([]byte, shadowsocksKeySize(method))
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func validShadowsocksKey(key, method string) bool {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	return err == nil && len(raw) == shadowsocksKeySize(method)
+}
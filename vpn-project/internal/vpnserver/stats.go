@@ -0,0 +1,257 @@
+package vpnserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// statsFlushTimeout bounds the counter flush that runs under the manager
+// lock before sing-box is reloaded or stopped.
+const statsFlushTimeout = 2 * time.Second
+
+type ClientTraffic struct {
+	UplinkBytes   int64      `json:"uplink_bytes"`
+	DownlinkBytes int64      `json:"downlink_bytes"`
+	PeriodBytes   int64      `json:"period_bytes"`
+	PeriodStart   *time.Time `json:"period_start,omitempty"`
+	UpdatedAt     *time.Time `json:"updated_at,omitempty"`
+}
+
+func (c Client) serverUserName() string {
+	if c.userName != "" {
+		return c.userName
+	}
+	return c.Name
+}
+
+// serverUserNames maps client IDs to the user names written into server.json.
+// sing-box keys its per-user counters by name, so clients sharing a display
+// name get their ID appended to keep the counters apart.
+func serverUserNames(clients []Client) map[string]string {
+	counts := make(map[string]int, len(clients))
+	for _, c := range clients {
+		counts[c.Name]++
+	}
+	names := make(map[string]string, len(clients))
+	for _, c := range clients {
+		name := c.Name
+		if counts[name] > 1 {
+			name = fmt.Sprintf("%s [%s]", name, c.ID)
+		}
+		names[c.ID] = name
+	}
+	return names
+}
+
+func statsAPIOptions(cfg Config, clients []Client) map[string]any {
+	users := make([]string, 0, len(clients))
+	for _, c := range clients {
+		users = append(users, c.serverUserName())
+	}
+	return map[string]any{
+		"listen": cfg.StatsAPIListen,
+		"stats": map[string]any{
+			"enabled": true,
+			"users":   users,
+		},
+	}
+}
+
+// PollTraffic reads and resets sing-box's per-user counters, adds them to the
+// totals persisted in traffic.json and cuts off clients over their quota.
+func (m *Manager) PollTraffic(ctx context.Context) (bool, error) {
+	m.mu.Lock()
+	addr := m.cfg.StatsAPIListen
+	if addr == "" || !m.interfaceRunningLocked() {
+		m.mu.Unlock()
+		return false, nil
+	}
+	stats := m.statsClientLocked()
+	m.mu.Unlock()
+
+	counters, err := stats.queryStats(ctx, "user>>>", true)
+	if err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.holdTrafficLocked(counters)
+	clients, err := m.loadClientsLocked()
+	if err != nil {
+		return false, err
+	}
+	traffic, err := m.loadTrafficLocked()
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now().UTC()
+	rolled := rollQuotaPeriods(clients, traffic, now)
+	counted := addTrafficCounters(clients, traffic, m.applied.users, m.unbankedTraffic, now)
+	if rolled || counted {
+		if err := m.saveTrafficLocked(traffic); err != nil {
+			return false, err
+		}
+	}
+	m.unbankedTraffic = nil
+	if !m.markQuotasLocked(clients, traffic, now) {
+		return counted, nil
+	}
+	if _, err := m.commitClientsLocked(clients); err != nil {
+		return counted, err
+	}
+	if err := m.reloadInterfaceLocked(); err != nil {
+		return counted, fmt.Errorf("reload sing-box after quota check: %w", err)
+	}
+	return counted, nil
+}
+
+// flushTrafficLocked banks sing-box's counters before a reload or stop
+// replaces the instance that holds them. Errors are only logged: a failed
+// flush must not block the config change.
+func (m *Manager) flushTrafficLocked() {
+	if m.cfg.StatsAPIListen == "" || !m.interfaceRunningLocked() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), statsFlushTimeout)
+	defer cancel()
+
+	counters, err := m.statsClientLocked().queryStats(ctx, "user>>>", true)
+	if err != nil {
+		m.logger.Printf("flush traffic counters before sing-box reload: %v", err)
+		return
+	}
+	m.holdTrafficLocked(counters)
+	clients, err := m.loadClientsLocked()
+	if err != nil {
+		m.logger.Printf("flush traffic counters before sing-box reload: %v", err)
+		return
+	}
+	traffic, err := m.loadTrafficLocked()
+	if err != nil {
+		m.logger.Printf("flush traffic counters before sing-box reload: %v", err)
+		return
+	}
+	if addTrafficCounters(clients, traffic, m.applied.users, m.unbankedTraffic, time.Now().UTC()) {
+		if err := m.saveTrafficLocked(traffic); err != nil {
+			m.logger.Printf("flush traffic counters before sing-box reload: %v", err)
+			return
+		}
+	}
+	m.unbankedTraffic = nil
+}
+
+// holdTrafficLocked keeps counters that sing-box has already reset until
+// they are saved to traffic.json; a failed save retries them on the next
+// poll or flush instead of losing them.
+func (m *Manager) holdTrafficLocked(counters map[string]int64) {
+	if len(counters) == 0 {
+		return
+	}
+	if m.unbankedTraffic == nil {
+		m.unbankedTraffic = make(map[string]int64, len(counters))
+	}
+	for stat, value := range counters {
+		m.unbankedTraffic[stat] += value
+	}
+}
+
+func (m *Manager) statsClientLocked() *statsClient {
+	if m.stats == nil || m.stats.addr != m.cfg.StatsAPIListen {
+		m.stats = newStatsClient(m.cfg.StatsAPIListen)
+	}
+	return m.stats
+}
+
+// serverUserIDs maps the user names written into server.json back to client
+// IDs; it is kept with the applied snapshot because counters are keyed by
+// the names of the running instance, which may predate a rename.
+func serverUserIDs(clients []Client) map[string]string {
+	byName := make(map[string]string, len(clients))
+	for id, name := range serverUserNames(clients) {
+		byName[name] = id
+	}
+	return byName
+}
+
+func addTrafficCounters(clients map[string]Client, traffic map[string]ClientTraffic, byName map[string]string, counters map[string]int64, now time.Time) bool {
+	changed := false
+	for stat, value := range counters {
+		name, direction, ok := userTrafficStat(stat)
+		if !ok || value <= 0 {
+			continue
+		}
+		id := byName[name]
+		if _, ok := clients[id]; !ok {
+			continue
+		}
+		t := traffic[id]
+		switch direction {
+		case "uplink":
+			t.UplinkBytes += value
+		case "downlink":
+			t.DownlinkBytes += value
+		default:
+			continue
+		}
+		t.PeriodBytes += value
+		t.UpdatedAt = &now
+		traffic[id] = t
+		changed = true
+	}
+	for id := range traffic {
+		if _, ok := clients[id]; !ok {
+			delete(traffic, id)
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (m *Manager) GetClientTraffic(clientID string) (ClientTraffic, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	traffic, err := m.loadTrafficLocked()
+	if err != nil {
+		return ClientTraffic{}, err
+	}
+	return traffic[clientID], nil
+}
+
+func (m *Manager) trafficPath() string {
+	return filepath.Join(m.cfg.StateDir, "traffic.json")
+}
+
+func (m *Manager) loadTrafficLocked() (map[string]ClientTraffic, error) {
+	traffic := map[string]ClientTraffic{}
+	raw, err := os.ReadFile(m.trafficPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return traffic, nil
+		}
+		return nil, fmt.Errorf("read traffic state: %w", err)
+	}
+	if err := json.Unmarshal(raw, &traffic); err != nil {
+		return nil, fmt.Errorf("parse traffic state: %w", err)
+	}
+	return traffic, nil
+}
+
+func (m *Manager) saveTrafficLocked(traffic map[string]ClientTraffic) error {
+	payload, err := marshalPretty(traffic)
+	if err != nil {
+		return fmt.Errorf("serialize traffic state: %w", err)
+	}
+	if err := writeSecretFile(m.trafficPath(), payload); err != nil {
+		return fmt.Errorf("write traffic state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package vpnserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sing-box compiles its own copy of V2Ray's stats proto under the package
+// experimental.v2rayapi (experimental/v2rayapi/stats.proto), so the V2Ray
+// service name v2ray.core.app.stats.command is not served.
+const queryStatsMethod = "/experimental.v2rayapi.StatsService/QueryStats"
+
+// statsClient speaks just enough gRPC (HTTP/2 cleartext plus hand-encoded
+// protobuf) to call QueryStats on sing-box's V2Ray API, so the manager does
+// not need a gRPC dependency for a single unary call.
+type statsClient struct {
+	addr string
+	http *http.Client
+}
+
+func newStatsClient(addr string) *statsClient {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	return &statsClient{
+		addr: addr,
+		http: &http.Client{
+			Transport: &http.Transport{Protocols: &protocols},
+			Timeout:   10 * time.Second,
+		},
+	}
+}
+
+func (c *statsClient) queryStats(ctx context.Context, pattern string, reset bool) (map[string]int64, error) {
+	var msg []byte
+	msg = appendProtoBytes(msg, 1, []byte(pattern))
+	if reset {
+		msg = appendProtoVarint(msg, 2, 1)
+	}
+
+	body := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(msg)))
+	body = append(body, msg...)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+c.addr+queryStatsMethod, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("query stats: %w", err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, fmt.Errorf("read stats response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("query stats: http status %d", resp.StatusCode)
+	}
+	status := firstNonEmpty(resp.Trailer.Get("Grpc-Status"), resp.Header.Get("Grpc-Status"))
+	if status != "" && status != "0" {
+		message := firstNonEmpty(resp.Trailer.Get("Grpc-Message"), resp.Header.Get("Grpc-Message"))
+		return nil, fmt.Errorf("query stats: grpc status %s: %s", status, message)
+	}
+
+	if len(raw) < 5 {
+		return map[string]int64{}, nil
+	}
+	size := binary.BigEndian.Uint32(raw[1:5])
+	if raw[0] != 0 || int(size) > len(raw)-5 {
+		return nil, errors.New("query stats: malformed grpc frame")
+	}
+	return decodeQueryStatsResponse(raw[5 : 5+size])
+}
+
+func decodeQueryStatsResponse(msg []byte) (map[string]int64, error) {
+	out := map[string]int64{}
+	err := walkProto(msg, func(field int, _ uint64, data []byte) error {
+		if field != 1 || data == nil {
+			return nil
+		}
+		var name string
+		var value int64
+		err := walkProto(data, func(field int, v uint64, data []byte) error {
+			switch field {
+			case 1:
+				name = string(data)
+			case 2:
+				value = int64(v)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		out[name] += value
+		return nil
+	})
+	return out, err
+}
+
+// userTrafficStat splits "user>>>NAME>>>traffic>>>uplink" into its parts.
+func userTrafficStat(stat string) (name, direction string, ok bool) {
+	parts := strings.Split(stat, ">>>")
+	if len(parts) != 4 || parts[0] != "user" || parts[2] != "traffic" {
+		return "", "", false
+	}
+	return parts[1], parts[3], true
+}
+
+func appendProtoVarint(b []byte, field int, v uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3)
+	return binary.AppendUvarint(b, v)
+}
+
+func appendProtoBytes(b []byte, field int, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// walkProto calls fn for every top-level field; varints are passed as v and
+// length-delimited fields as data.
+func walkProto(b []byte, fn func(field int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("protobuf: bad field key")
+		}
+		b = b[n:]
+		field, wireType := int(key>>3), key&7
+
+		switch wireType {
+		case 0:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return errors.New("protobuf: bad varint")
+			}
+			b = b[n:]
+			if err := fn(field, v, nil); err != nil {
+				return err
+			}
+		case 1:
+			if len(b) < 8 {
+				return errors.New("protobuf: short fixed64")
+			}
+			b = b[8:]
+		case 2:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return errors.New("protobuf: bad length")
+			}
+			data := b[n : n+int(size)]
+			b = b[n+int(size):]
+			if err := fn(field, 0, data); err != nil {
+				return err
+			}
+		case 5:
+			if len(b) < 4 {
+				return errors.New("protobuf: short fixed32")
+			}
+			b = b[4:]
+		default:
+			return fmt.Errorf("protobuf: unsupported wire type %d", wireType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+package vpnserver
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStatsServer answers QueryStats over h2c like sing-box's V2Ray API,
+// handing out each queued batch of counters once.
+type fakeStatsServer struct {
+	srv *httptest.Server
+
+	mu      sync.Mutex
+	batches []map[string]int64
+	queries []string
+}
+
+func newFakeStatsServer(t *testing.T, batches ...map[string]int64) *fakeStatsServer {
+	t.Helper()
+	f := &fakeStatsServer{batches: batches}
+	f.srv = httptest.NewUnstartedServer(http.HandlerFunc(f.handle))
+	f.srv.Config.Protocols = new(http.Protocols)
+	f.srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	f.srv.Start()
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeStatsServer) addr() string {
+	return strings.TrimPrefix(f.srv.URL, "http://")
+}
+
+func (f *fakeStatsServer) handle(w http.ResponseWriter, r *http.Request) {
+	// Spelled out rather than using queryStatsMethod so a wrong service name
+	// in the client fails here.
+	if r.ProtoMajor != 2 || r.URL.Path != "/experimental.v2rayapi.StatsService/QueryStats" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	raw, _ := io.ReadAll(r.Body)
+	var pattern string
+	var reset bool
+	if len(raw) >= 5 {
+		_ = walkProto(raw[5:], func(field int, v uint64, data []byte) error {
+			switch field {
+			case 1:
+				pattern = string(data)
+			case 2:
+				reset = v == 1
+			}
+			return nil
+		})
+	}
+
+	f.mu.Lock()
+	f.queries = append(f.queries, pattern)
+	var batch map[string]int64
+	if reset && len(f.batches) > 0 {
+		batch, f.batches = f.batches[0], f.batches[1:]
+	}
+	f.mu.Unlock()
+
+	var msg []byte
+	for name, value := range batch {
+		var stat []byte
+		stat = appendProtoBytes(stat, 1, []byte(name))
+		stat = appendProtoVarint(stat, 2, uint64(value))
+		msg = appendProtoBytes(msg, 1, stat)
+	}
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status")
+	_, _ = w.Write(append(frame, msg...))
+	w.Header().Set("Grpc-Status", "0")
+}
+
+// fakeSingBoxReload is the trap a fake sing-box runs on SIGHUP: it runs the
+// given commands and then logs the line the manager waits for.
+func fakeSingBoxReload(commands string) string {
+	return "trap '" + commands + "echo \"INFO sing-box started (0.00s)\"' HUP\n"
+}
+
+func startFakeSingBox(t *testing.T, mgr *Manager) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake sing-box is a shell script")
+	}
+	script := filepath.Join(t.TempDir(), "sing-box")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"+fakeSingBoxReload("")+"while true; do sleep 0.05; done\n"), 0o755); err != nil {
+		t.Fatalf("write fake sing-box: %v", err)
+	}
+	mgr.cfg.SingBoxBinary = script
+	if err := mgr.StartInterface(); err != nil {
+		t.Fatalf("start interface: %v", err)
+	}
+	t.Cleanup(func() { _ = mgr.StopInterface() })
+}
+
+func TestPollTraffic_AccumulatesAndPersistsPerClientTotals(t *testing.T) {
+	mgr := newTestManager(t)
+	alice, _, err := mgr.CreateClient("alice", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	stats := newFakeStatsServer(t,
+		map[string]int64{
+			"user>>>alice>>>traffic>>>uplink":   100,
+			"user>>>alice>>>traffic>>>downlink": 2000,
+			"user>>>ghost>>>traffic>>>uplink":   5,
+		},
+		map[string]int64{
+			"user>>>alice>>>traffic>>>uplink":   50,
+			"user>>>alice>>>traffic>>>downlink": 1000,
+		},
+	)
+	mgr.cfg.StatsAPIListen = stats.addr()
+	startFakeSingBox(t, mgr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		if _, err := mgr.PollTraffic(ctx); err != nil {
+			t.Fatalf("poll traffic: %v", err)
+		}
+	}
+	stats.mu.Lock()
+	queries := stats.queries
+	stats.mu.Unlock()
+	if len(queries) != 2 || queries[0] != "user>>>" {
+		t.Fatalf("unexpected stats queries: %v", queries)
+	}
+
+	restarted := NewManager(mgr.cfg, log.New(io.Discard, "", 0))
+	traffic, err := restarted.GetClientTraffic(alice.ID)
+	if err != nil {
+		t.Fatalf("get traffic: %v", err)
+	}
+	if traffic.UplinkBytes != 150 || traffic.DownlinkBytes != 3000 || traffic.UpdatedAt == nil {
+		t.Fatalf("unexpected persisted traffic: %#v", traffic)
+	}
+
+	status, err := restarted.GetStatus()
+	if err != nil {
+		t.Fatalf("get status: %v", err)
+	}
+	for _, c := range status.Clients {
+		if c.ID == alice.ID && (c.Traffic == nil || c.Traffic.DownlinkBytes != 3000) {
+			t.Fatalf("status must include client traffic: %#v", c)
+		}
+	}
+
+	handler := NewHTTPHandler(restarted, log.New(io.Discard, "", 0))
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/clients/"+alice.ID, nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		ID      string        `json:"id"`
+		Traffic ClientTraffic `json:"traffic"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.ID != alice.ID || resp.Traffic.UplinkBytes != 150 {
+		t.Fatalf("unexpected client response: %s", rec.Body.String())
+	}
+}
+
+func TestBuildServerConfig_StatsAPIUsesDistinctUserNames(t *testing.T) {
+	cfg := newTestManager(t).cfg
+	cfg.StatsAPIListen = "127.0.0.1:10085"
+	clients := []Client{
+		{ID: "phone", Name: "Alice", UUID: "11111111-1111-1111-1111-111111111111"},
+		{ID: "laptop", Name: "Alice", UUID: "22222222-2222-2222-2222-222222222222"},
+		{ID: "bob", Name: "Bob", UUID: "33333333-3333-3333-3333-333333333333"},
+	}
+
+	raw, err := json.Marshal(buildServerConfigMap(cfg, clients))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		Experimental struct {
+			V2RayAPI struct {
+				Listen string `json:"listen"`
+				Stats  struct {
+					Enabled bool     `json:"enabled"`
+					Users   []string `json:"users"`
+				} `json:"stats"`
+			} `json:"v2ray_api"`
+		} `json:"experimental"`
+	}
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	api := parsed.Experimental.V2RayAPI
+	want := []string{"Alice [phone]", "Alice [laptop]", "Bob"}
+	if api.Listen != cfg.StatsAPIListen || !api.Stats.Enabled || strings.Join(api.Stats.Users, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected v2ray_api options: %#v", api)
+	}
+	if !strings.Contains(string(raw), `"name":"Alice [laptop]"`) {
+		t.Fatalf("inbound users must use the same names as the stats API: %s", raw)
+	}
+
+	cfg.StatsAPIListen = ""
+	raw, _ = json.Marshal(buildServerConfigMap(cfg, clients))
+	if strings.Contains(string(raw), "v2ray_api") {
+		t.Fatalf("stats API must be opt-in: %s", raw)
+	}
+}
+
+func TestReload_FlushesTrafficCountersFirst(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake sing-box is a shell script")
+	}
+
+	mgr := newTestManager(t)
+	alice, _, err := mgr.CreateClient("alice", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	stats := newFakeStatsServer(t, map[string]int64{"user>>>alice>>>traffic>>>uplink": 700})
+	mgr.cfg.StatsAPIListen = stats.addr()
+
+	script := filepath.Join(t.TempDir(), "sing-box")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"+fakeSingBoxReload("")+"while true; do sleep 0.05; done\n"), 0o755); err != nil {
+		t.Fatalf("write fake sing-box: %v", err)
+	}
+	mgr.cfg.SingBoxBinary = script
+	if err := mgr.StartInterface(); err != nil {
+		t.Fatalf("start interface: %v", err)
+	}
+	t.Cleanup(func() { _ = mgr.StopInterface() })
+
+	// Renaming reloads sing-box; the counters still belong to the old name.
+	name := "alice-renamed"
+	if _, err := mgr.UpdateClient(alice.ID, ClientUpdate{Name: &name}); err != nil {
+		t.Fatalf("update client: %v", err)
+	}
+	traffic, err := mgr.GetClientTraffic(alice.ID)
+	if err != nil {
+		t.Fatalf("get traffic: %v", err)
+	}
+	if traffic.UplinkBytes != 700 {
+		t.Fatalf("counters must be banked before the reload, got %#v", traffic)
+	}
+}
+
+func TestPollTraffic_KeepsResetCountersWhenSavingFails(t *testing.T) {
+	mgr := newTestManager(t)
+	alice, _, err := mgr.CreateClient("alice", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	stats := newFakeStatsServer(t,
+		map[string]int64{"user>>>alice>>>traffic>>>downlink": 4000},
+		map[string]int64{"user>>>alice>>>traffic>>>downlink": 10},
+	)
+	mgr.cfg.StatsAPIListen = stats.addr()
+	startFakeSingBox(t, mgr)
+
+	// A directory in place of traffic.json makes loading and saving fail.
+	if err := os.MkdirAll(filepath.Join(mgr.trafficPath(), "blocked"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := mgr.PollTraffic(ctx); err == nil {
+		t.Fatalf("poll must report the unreadable traffic state")
+	}
+
+	if err := os.RemoveAll(mgr.trafficPath()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.PollTraffic(ctx); err != nil {
+		t.Fatalf("poll traffic: %v", err)
+	}
+	traffic, err := mgr.GetClientTraffic(alice.ID)
+	if err != nil {
+		t.Fatalf("get traffic: %v", err)
+	}
+	if traffic.DownlinkBytes != 4010 {
+		t.Fatalf("counters read before the failed save must not be lost, got %#v", traffic)
+	}
+}
+
+func TestBuildServerConfig_RejectsLoopbackBeforeUserRules(t *testing.T) {
+	cfg := newTestManager(t).cfg
+	cfg.StatsAPIListen = "127.0.0.1:10085"
+	cfg.ClashAPIListen = "127.0.0.1:9090"
+	clients := []Client{{ID: "alice", Name: "Alice", UUID: "11111111-1111-1111-1111-111111111111"}}
+
+	raw, err := json.Marshal(buildServerConfigMap(cfg, clients))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		Route struct {
+			Rules []struct {
+				IPIsPrivate bool     `json:"ip_is_private"`
+				IPCIDR      []string `json:"ip_cidr"`
+				AuthUser    []string `json:"auth_user"`
+				Action      string   `json:"action"`
+			} `json:"rules"`
+		} `json:"route"`
+	}
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	rules := parsed.Route.Rules
+	if len(rules) != 2 {
+		t.Fatalf("unexpected route rules: %s", raw)
+	}
+	first := rules[0]
+	if !first.IPIsPrivate || first.Action != "reject" || strings.Join(first.IPCIDR, ",") != "127.0.0.0/8,::1/128" {
+		t.Fatalf("first route rule must reject the server's own APIs: %s", raw)
+	}
+	if len(rules[1].AuthUser) != 1 || rules[1].AuthUser[0] != "Alice" {
+		t.Fatalf("per-user rules must follow the reject rule: %s", raw)
+	}
+
+	cfg.ClashAPIListen = ""
+	raw, _ = json.Marshal(buildServerConfigMap(cfg, clients))
+	if !strings.Contains(string(raw), `"ip_is_private":true`) {
+		t.Fatalf("loopback must be rejected without the Clash API too: %s", raw)
+	}
+}
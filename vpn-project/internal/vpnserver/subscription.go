@@ -0,0 +1,104 @@
+package vpnserver
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const subscriptionPathPrefix = "/sub/"
+
+func generateSubscriptionToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func fillSubscriptionToken(c *Client) (bool, error) {
+	if strings.TrimSpace(c.SubscriptionToken) != "" {
+		return false, nil
+	}
+	token, err := generateSubscriptionToken()
+	if err != nil {
+		return false, fmt.Errorf("generate subscription token: %w", err)
+	}
+	c.SubscriptionToken = token
+	return true, nil
+}
+
+// subscriptionURL joins the public API base URL and the token path.
+func subscriptionURL(baseURL, token string) string {
+	return strings.TrimRight(baseURL, "/") + subscriptionPathPrefix + token
+}
+
+// ClientBySubscriptionToken resolves the public /sub/{token} link to its
+// client, current traffic and share links.
+func (m *Manager) ClientBySubscriptionToken(token string) (Client, ClientTraffic, []string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clients, err := m.loadClientsLocked()
+	if err != nil {
+		return Client{}, ClientTraffic{}, nil, err
+	}
+	for _, c := range clients {
+		if !secureTokenEqual(token, c.SubscriptionToken) {
+			continue
+		}
+		traffic, err := m.loadTrafficLocked()
+		if err != nil {
+			return Client{}, ClientTraffic{}, nil, err
+		}
+		return c, traffic[c.ID], buildClientShareURIs(m.cfg, c), nil
+	}
+	return Client{}, ClientTraffic{}, nil, os.ErrNotExist
+}
+
+// RotateSubscriptionToken revokes the client's subscription link and issues
+// a new one; proxy credentials stay unchanged.
+func (m *Manager) RotateSubscriptionToken(clientID string) (Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clients, err := m.loadClientsLocked()
+	if err != nil {
+		return Client{}, err
+	}
+	c, ok := clients[clientID]
+	if !ok {
+		return Client{}, os.ErrNotExist
+	}
+
+	c.SubscriptionToken = ""
+	if _, err := fillSubscriptionToken(&c); err != nil {
+		return Client{}, err
+	}
+	clients[clientID] = c
+	if err := m.saveClientsLocked(clients); err != nil {
+		return Client{}, err
+	}
+	m.logger.Printf("client %s subscription token rotated", c.ID)
+	return c, nil
+}
+
+// subscriptionUserinfo renders the Subscription-Userinfo header that
+// v2rayN/Clash-style apps show as usage and expiry. Monthly usage is not
+// split by direction, so it is reported as download.
+func subscriptionUserinfo(c Client, t ClientTraffic) string {
+	upload, download := t.UplinkBytes, t.DownlinkBytes
+	var total, expire int64
+	if c.Quota != nil {
+		total = c.Quota.Bytes
+		if c.Quota.Period == QuotaPeriodMonthly {
+			upload, download = 0, t.PeriodBytes
+		}
+	}
+	if c.ExpiresAt != nil {
+		expire = c.ExpiresAt.Unix()
+	}
+	return fmt.Sprintf("upload=%d; download=%d; total=%d; expire=%d", upload, download, total, expire)
+}
@@ -0,0 +1,183 @@
+package vpnserver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fetchSubscription(t *testing.T, handler http.Handler, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "http://vpn.example.com"+path, nil)
+	req.RemoteAddr = "198.51.100.20:4000"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSubscriptionEndpoint_ServesShareLinksByToken(t *testing.T) {
+	mgr := newTestManager(t)
+	mgr.cfg.APIToken = "admin-token"
+	mgr.cfg.SubscriptionBaseURL = "https://vpn.example.com:8080/"
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	c, _, err := mgr.CreateClient("alice", &expires)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	if c.SubscriptionToken == "" {
+		t.Fatalf("new clients must get a subscription token")
+	}
+	if got, want := subscriptionURL(mgr.cfg.SubscriptionBaseURL, c.SubscriptionToken), "https://vpn.example.com:8080/sub/"+c.SubscriptionToken; got != want {
+		t.Fatalf("subscription url = %q, want %q", got, want)
+	}
+
+	handler := NewHTTPHandler(mgr, log.New(io.Discard, "", 0))
+	rec := fetchSubscription(t, handler, "/sub/"+c.SubscriptionToken)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+	links, err := base64.StdEncoding.DecodeString(rec.Body.String())
+	if err != nil {
+		t.Fatalf("subscription body must be base64: %v", err)
+	}
+	if !strings.HasPrefix(string(links), "vless://"+c.UUID+"@") {
+		t.Fatalf("unexpected subscription links: %s", links)
+	}
+	if got := rec.Header().Get("Subscription-Userinfo"); got != "upload=0; download=0; total=0; expire=1893456000" {
+		t.Fatalf("unexpected Subscription-Userinfo: %q", got)
+	}
+
+	if rec := fetchSubscription(t, handler, "/sub/not-a-token"); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown token must 404, got %d", rec.Code)
+	}
+
+	rotated, _, err := mgr.RotateClientCredentials(c.ID)
+	if err != nil {
+		t.Fatalf("rotate credentials: %v", err)
+	}
+	if rotated.SubscriptionToken != c.SubscriptionToken {
+		t.Fatalf("credential rotation must keep the subscription link")
+	}
+	links, _ = base64.StdEncoding.DecodeString(fetchSubscription(t, handler, "/sub/"+c.SubscriptionToken).Body.String())
+	if !strings.Contains(string(links), rotated.UUID) {
+		t.Fatalf("subscription must follow credential rotation: %s", links)
+	}
+
+	revoked, err := mgr.RotateSubscriptionToken(c.ID)
+	if err != nil {
+		t.Fatalf("rotate subscription token: %v", err)
+	}
+	if revoked.UUID != rotated.UUID || revoked.SubscriptionToken == c.SubscriptionToken {
+		t.Fatalf("revoking the subscription must only change the token: %#v", revoked)
+	}
+	if rec := fetchSubscription(t, handler, "/sub/"+c.SubscriptionToken); rec.Code != http.StatusNotFound {
+		t.Fatalf("revoked token must 404, got %d", rec.Code)
+	}
+	if rec := fetchSubscription(t, handler, "/sub/"+revoked.SubscriptionToken); rec.Code != http.StatusOK {
+		t.Fatalf("new token must work, got %d", rec.Code)
+	}
+}
+
+func TestSubscriptionURL_FallsBackToRequestHost(t *testing.T) {
+	mgr := newTestManager(t)
+	c, _, err := mgr.CreateClient("alice", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	fetch := func(handler http.Handler, headers map[string]string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://vpn.example.com:18080/clients/"+c.ID+"/subscription", nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var resp map[string]string
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return resp["subscription_url"]
+	}
+	spoofed := map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.example.net"}
+
+	direct := NewHTTPHandler(mgr, log.New(io.Discard, "", 0))
+	if got, want := fetch(direct, nil), "http://vpn.example.com:18080/sub/"+c.SubscriptionToken; got != want {
+		t.Fatalf("subscription url = %q, want %q", got, want)
+	}
+	if got, want := fetch(direct, spoofed), "http://vpn.example.com:18080/sub/"+c.SubscriptionToken; got != want {
+		t.Fatalf("forwarded headers from an untrusted peer must be ignored: got %q, want %q", got, want)
+	}
+
+	mgr.cfg.TrustedProxies = []string{"127.0.0.0/8"}
+	proxied := NewHTTPHandler(mgr, log.New(io.Discard, "", 0))
+	if got, want := fetch(proxied, map[string]string{"X-Forwarded-Proto": "https"}), "https://vpn.example.com:18080/sub/"+c.SubscriptionToken; got != want {
+		t.Fatalf("subscription url behind a TLS proxy = %q, want %q", got, want)
+	}
+	if got, want := fetch(proxied, spoofed), "https://evil.example.net/sub/"+c.SubscriptionToken; got != want {
+		t.Fatalf("trusted proxy host = %q, want %q", got, want)
+	}
+}
+
+func TestTrustedProxyPrefixes_RejectsInvalidEntries(t *testing.T) {
+	cfg := Config{TrustedProxies: []string{"10.0.0.1", "192.168.0.0/16"}}
+	prefixes, err := cfg.trustedProxyPrefixes()
+	if err != nil || len(prefixes) != 2 || prefixes[0].Bits() != 32 {
+		t.Fatalf("unexpected prefixes %v: %v", prefixes, err)
+	}
+	cfg.TrustedProxies = []string{"proxy.local"}
+	if _, err := cfg.trustedProxyPrefixes(); err == nil {
+		t.Fatalf("host names must be rejected")
+	}
+}
+
+func TestSubscriptionEndpoint_ServesRawProfiles(t *testing.T) {
+	mgr := newTestManager(t)
+	mgr.cfg.APIToken = "admin-token"
+	c, _, err := mgr.CreateClient("alice", nil)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	handler := NewHTTPHandler(mgr, log.New(io.Discard, "", 0))
+	path := "/sub/" + c.SubscriptionToken + "?format="
+
+	rec := fetchSubscription(t, handler, path+"clash")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/yaml") {
+		t.Fatalf("got status %d, content type %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	if body := rec.Body.String(); !strings.HasPrefix(body, "mixed-port: 7890\n") || !strings.Contains(body, "    uuid: "+c.UUID+"\n") {
+		t.Fatalf("clash body must be the raw YAML profile:\n%s", body)
+	}
+	if rec.Header().Get("Subscription-Userinfo") == "" {
+		t.Fatalf("raw profiles must keep the Subscription-Userinfo header")
+	}
+
+	for _, format := range []string{"singbox", "singbox-remote", "xray"} {
+		rec := fetchSubscription(t, handler, path+format)
+		if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+			t.Fatalf("%s: got status %d, content type %q", format, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		var config struct {
+			Outbounds []map[string]any `json:"outbounds"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &config); err != nil {
+			t.Fatalf("%s body must be the raw JSON config: %v", format, err)
+		}
+		if len(config.Outbounds) == 0 {
+			t.Fatalf("%s config has no outbounds: %s", format, rec.Body.String())
+		}
+	}
+
+	if rec := fetchSubscription(t, handler, path+"wireguard"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown format must be rejected, got %d", rec.Code)
+	}
+	if rec := fetchSubscription(t, handler, "/sub/not-a-token?format=clash"); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown token must 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,154 @@
+package vpnserver
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+type SupervisorStatus struct {
+	Restarts      int        `json:"restarts"`
+	CrashCount    int        `json:"crash_count"`
+	LastExitCode  *int       `json:"last_exit_code,omitempty"`
+	LastFailure   string     `json:"last_failure,omitempty"`
+	LastFailureAt *time.Time `json:"last_failure_at,omitempty"`
+	NextRestartAt *time.Time `json:"next_restart_at,omitempty"`
+	GaveUp        bool       `json:"gave_up"`
+}
+
+type supervisorState struct {
+	status        SupervisorStatus
+	wantRunning   bool
+	recentCrashes []time.Time
+	restartTimer  *time.Timer
+}
+
+func (m *Manager) resetSupervisorLocked(wantRunning bool) {
+	sup := &m.supervisor
+	if sup.restartTimer != nil {
+		sup.restartTimer.Stop()
+		sup.restartTimer = nil
+	}
+	sup.wantRunning = wantRunning
+	sup.recentCrashes = nil
+	sup.status.GaveUp = false
+	sup.status.NextRestartAt = nil
+}
+
+func (m *Manager) supervisorStatusLocked() SupervisorStatus {
+	return m.supervisor.status
+}
+
+func (m *Manager) handleUnexpectedExitLocked(exitErr error) {
+	code := 0
+	reason := "sing-box exited unexpectedly"
+	var ee *exec.ExitError
+	if errors.As(exitErr, &ee) {
+		code = ee.ExitCode()
+	}
+	if exitErr != nil {
+		reason = "sing-box exited: " + exitErr.Error()
+	}
+	if line := lastLogLine(m.serverLogPath); line != "" {
+		reason += ": " + line
+	}
+	m.logger.Printf("%s (exit code %d)", reason, code)
+	m.recordFailureLocked(reason, &code)
+}
+
+func (m *Manager) recordFailureLocked(reason string, exitCode *int) {
+	sup := &m.supervisor
+	now := time.Now().UTC()
+
+	sup.status.CrashCount++
+	sup.status.LastExitCode = exitCode
+	sup.status.LastFailure = reason
+	sup.status.LastFailureAt = &now
+
+	if !sup.wantRunning {
+		return
+	}
+
+	window := m.cfg.RestartWindow
+	recent := sup.recentCrashes[:0]
+	for _, at := range sup.recentCrashes {
+		if now.Sub(at) < window {
+			recent = append(recent, at)
+		}
+	}
+	sup.recentCrashes = append(recent, now)
+
+	if m.cfg.RestartMaxCrashes > 0 && len(sup.recentCrashes) > m.cfg.RestartMaxCrashes {
+		sup.status.GaveUp = true
+		sup.status.NextRestartAt = nil
+		m.logger.Printf("sing-box crashed %d times within %s, giving up until POST /start",
+			len(sup.recentCrashes), window)
+		return
+	}
+
+	delay := restartBackoff(m.cfg.RestartBackoffMin, m.cfg.RestartBackoffMax, len(sup.recentCrashes))
+	next := now.Add(delay)
+	sup.status.NextRestartAt = &next
+	sup.restartTimer = time.AfterFunc(delay, m.restartAfterCrash)
+	m.logger.Printf("restarting sing-box in %s", delay)
+}
+
+func (m *Manager) restartAfterCrash() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sup := &m.supervisor
+	sup.restartTimer = nil
+	sup.status.NextRestartAt = nil
+	if !sup.wantRunning || m.interfaceRunningLocked() {
+		return
+	}
+
+	sup.status.Restarts++
+	if err := m.startInterfaceLocked(); err != nil {
+		m.logger.Printf("sing-box restart failed: %v", err)
+		m.recordFailureLocked(err.Error(), nil)
+	}
+}
+
+func restartBackoff(minDelay, maxDelay time.Duration, attempt int) time.Duration {
+	if minDelay <= 0 {
+		minDelay = time.Second
+	}
+	if maxDelay < minDelay {
+		maxDelay = minDelay
+	}
+	delay := minDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= maxDelay {
+			return maxDelay
+		}
+	}
+	return delay
+}
+
+func lastLogLine(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	const tailSize = 4096
+	if st, err := f.Stat(); err == nil && st.Size() > tailSize {
+		if _, err := f.Seek(-tailSize, io.SeekEnd); err != nil {
+			return ""
+		}
+	}
+	raw, err := io.ReadAll(f)
+	if err != nil {
+		return ""
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
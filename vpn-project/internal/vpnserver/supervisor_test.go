@@ -0,0 +1,74 @@
+package vpnserver
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestartBackoff(t *testing.T) {
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{10, 30 * time.Second},
+	}
+	for _, tc := range cases {
+		if got := restartBackoff(time.Second, 30*time.Second, tc.attempt); got != tc.want {
+			t.Fatalf("attempt %d: got %s, want %s", tc.attempt, got, tc.want)
+		}
+	}
+}
+
+func TestSupervisor_RestartsCrashedProcessAndGivesUp(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake sing-box is a shell script")
+	}
+
+	mgr := newTestManager(t)
+	script := filepath.Join(t.TempDir(), "sing-box")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho 'FATAL bind: address already in use' >&2\nexit 3\n"), 0o755); err != nil {
+		t.Fatalf("write fake sing-box: %v", err)
+	}
+	mgr.cfg.SingBoxBinary = script
+	mgr.cfg.RestartBackoffMin = 10 * time.Millisecond
+	mgr.cfg.RestartBackoffMax = 20 * time.Millisecond
+	mgr.cfg.RestartMaxCrashes = 2
+	mgr.cfg.RestartWindow = time.Minute
+
+	if err := mgr.StartInterface(); err != nil {
+		t.Fatalf("start interface: %v", err)
+	}
+	t.Cleanup(func() { _ = mgr.StopInterface() })
+
+	deadline := time.Now().Add(3 * time.Second)
+	var status SupervisorStatus
+	for {
+		mgr.mu.Lock()
+		status = mgr.supervisorStatusLocked()
+		mgr.mu.Unlock()
+		if status.GaveUp {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("supervisor did not give up: %#v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if status.CrashCount != 3 || status.Restarts != 2 {
+		t.Fatalf("got crashes=%d restarts=%d, want 3/2", status.CrashCount, status.Restarts)
+	}
+	if status.LastExitCode == nil || *status.LastExitCode != 3 {
+		t.Fatalf("unexpected last exit code: %v", status.LastExitCode)
+	}
+	if !strings.Contains(status.LastFailure, "address already in use") {
+		t.Fatalf("last failure must include sing-box output, got %q", status.LastFailure)
+	}
+}
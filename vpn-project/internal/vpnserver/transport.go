@@ -0,0 +1,37 @@
+package vpnserver
+
+import "net/url"
+
+func transportOptions(cfg Config) map[string]any {
+	switch cfg.transportType() {
+	case TransportGRPC:
+		return map[string]any{
+			"type":         "grpc",
+			"service_name": cfg.GRPCServiceName,
+		}
+	case TransportHTTPUpgrade:
+		return map[string]any{
+			"type": "httpupgrade",
+			"path": cfg.WebsocketPath,
+		}
+	case TransportTCP:
+		return nil
+	default:
+		return map[string]any{
+			"type": "ws",
+			"path": cfg.WebsocketPath,
+		}
+	}
+}
+
+func setTransportShareParams(query url.Values, cfg Config) {
+	transport := cfg.transportType()
+	query.Set("type", transport)
+	switch transport {
+	case TransportGRPC:
+		query.Set("serviceName", cfg.GRPCServiceName)
+		query.Set("mode", "gun")
+	case TransportWS, TransportHTTPUpgrade:
+		query.Set("path", cfg.WebsocketPath)
+	}
+}
@@ -0,0 +1,76 @@
+package vpnserver
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNormalizeTransport(t *testing.T) {
+	cases := []struct {
+		raw, security, want string
+	}{
+		{"", SecurityTLS, TransportWS},
+		{"", SecurityReality, TransportTCP},
+		{"GRPC", SecurityTLS, TransportGRPC},
+		{"httpupgrade", SecurityTLS, TransportHTTPUpgrade},
+		{"quic", SecurityTLS, TransportWS},
+	}
+	for _, tc := range cases {
+		if got := normalizeTransport(tc.raw, tc.security); got != tc.want {
+			t.Fatalf("normalizeTransport(%q, %q) = %q, want %q", tc.raw, tc.security, got, tc.want)
+		}
+	}
+}
+
+func TestTransportSelection_DrivesServerClientAndShareURI(t *testing.T) {
+	base := Config{
+		EndpointHost:    "vpn.example.com",
+		ListenPort:      443,
+		WebsocketPath:   "/vpn",
+		GRPCServiceName: "tunnel",
+		TLSServerName:   "vpn.example.com",
+	}
+	client := Client{Name: "alice", UUID: "11111111-1111-1111-1111-111111111111"}
+
+	cases := []struct {
+		transport string
+		wantType  any
+		shareKey  string
+		shareVal  string
+	}{
+		{TransportWS, "ws", "path", "/vpn"},
+		{TransportGRPC, "grpc", "serviceName", "tunnel"},
+		{TransportHTTPUpgrade, "httpupgrade", "path", "/vpn"},
+		{TransportTCP, nil, "flow", "xtls-rprx-vision"},
+	}
+	for _, tc := range cases {
+		cfg := base
+		cfg.Transport = tc.transport
+
+		server := buildServerConfigMap(cfg, []Client{client})
+		inbound := server["inbounds"].([]any)[0].(map[string]any)
+		outbound := buildClientConfigMap(cfg, client)["outbounds"].([]any)[0].(map[string]any)
+
+		for name, section := range map[string]map[string]any{"inbound": inbound, "outbound": outbound} {
+			transport, _ := section["transport"].(map[string]any)
+			var gotType any
+			if transport != nil {
+				gotType = transport["type"]
+			}
+			if gotType != tc.wantType {
+				t.Fatalf("%s %s transport type: got %#v, want %#v", tc.transport, name, gotType, tc.wantType)
+			}
+		}
+
+		parsed, err := url.Parse(buildClientShareURI(cfg, client))
+		if err != nil {
+			t.Fatalf("parse share uri: %v", err)
+		}
+		if parsed.Query().Get("type") != tc.transport {
+			t.Fatalf("share uri type: got %q, want %q", parsed.Query().Get("type"), tc.transport)
+		}
+		if parsed.Query().Get(tc.shareKey) != tc.shareVal {
+			t.Fatalf("share uri %s: got %q, want %q", tc.shareKey, parsed.Query().Get(tc.shareKey), tc.shareVal)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package vpnserver
+
+import (
+	"errors"
+	"strings"
+)
+
+var errXrayUnsupported = errors.New("xray does not support any of the configured inbounds")
+
+func (vlessProtocol) xrayOutbound(cfg Config, tag string, c Client) map[string]any {
+	host, port := resolveEndpointHostPort(cfg.EndpointHost, cfg.ListenPort)
+	user := map[string]any{
+		"id":         c.UUID,
+		"encryption": "none",
+	}
+	if flow := cfg.vlessFlow(); flow != "" {
+		user["flow"] = flow
+	}
+	return map[string]any{
+		"tag":      tag,
+		"protocol": "vless",
+		"settings": map[string]any{
+			"vnext": []any{map[string]any{
+				"address": host,
+				"port":    port,
+				"users":   []any{user},
+			}},
+		},
+		"streamSettings": xrayStreamSettings(cfg),
+	}
+}
+
+func (trojanProtocol) xrayOutbound(cfg Config, tag string, c Client) map[string]any {
+	host, port := resolveEndpointHostPort(cfg.EndpointHost, cfg.ListenPort)
+	return map[string]any{
+		"tag":      tag,
+		"protocol": "trojan",
+		"settings": map[string]any{
+			"servers": []any{map[string]any{
+				"address":  host,
+				"port":     port,
+				"password": c.Password,
+			}},
+		},
+		"streamSettings": xrayStreamSettings(cfg),
+	}
+}
+
+func (shadowsocksProtocol) xrayOutbound(cfg Config, tag string, c Client) map[string]any {
+	host, port := resolveEndpointHostPort(cfg.EndpointHost, cfg.ListenPort)
+	return map[string]any{
+		"tag":      tag,
+		"protocol": "shadowsocks",
+		"settings": map[string]any{
+			"servers": []any{map[string]any{
+				"address":  host,
+				"port":     port,
+				"method":   cfg.ShadowsocksMethod,
+				"password": shadowsocksClientPassword(cfg, c),
+			}},
+		},
+	}
+}
+
+func (hysteria2Protocol) xrayOutbound(Config, string, Client) map[string]any {
+	return nil
+}
+
+func xrayStreamSettings(cfg Config) map[string]any {
+	stream := map[string]any{}
+	switch cfg.transportType() {
+	case TransportGRPC:
+		stream["network"] = "grpc"
+		stream["grpcSettings"] = map[string]any{"serviceName": cfg.GRPCServiceName}
+	case TransportHTTPUpgrade:
+		stream["network"] = "httpupgrade"
+		stream["httpupgradeSettings"] = map[string]any{"path": cfg.WebsocketPath}
+	case TransportTCP:
+		stream["network"] = "tcp"
+	default:
+		stream["network"] = "ws"
+		stream["wsSettings"] = map[string]any{"path": cfg.WebsocketPath}
+	}
+
+	if cfg.securityType() == SecurityReality {
+		stream["security"] = "reality"
+		stream["realitySettings"] = map[string]any{
+			"serverName":  cfg.RealityServerName,
+			"fingerprint": cfg.RealityFingerprint,
+			"publicKey":   cfg.RealityPublicKey,
+			"shortId":     firstRealityShortID(cfg),
+		}
+		return stream
+	}
+
+	tls := map[string]any{}
+	if strings.TrimSpace(cfg.TLSServerName) != "" {
+		tls["serverName"] = cfg.TLSServerName
+	}
+	if cfg.PinnedCertPEM != "" {
+		// A self-signed certificate is its own trust anchor.
+		tls["certificates"] = []any{map[string]any{
+			"usage":       "verify",
+			"certificate": strings.Split(strings.TrimSpace(cfg.PinnedCertPEM), "\n"),
+		}}
+	} else if cfg.ClientInsecureTLS {
+		tls["allowInsecure"] = true
+	}
+	stream["security"] = "tls"
+	stream["tlsSettings"] = tls
+	return stream
+}
+
+// buildXrayConfig renders an Xray client with local SOCKS and HTTP proxies.
+// Several inbounds become "proxy-<tag>" outbounds behind a leastPing
+// balancer, mirroring the sing-box urltest group.
+func buildXrayConfig(cfg Config, c Client) (map[string]any, error) {
+	inbounds := cfg.inboundConfigs()
+	var proxies []any
+	var tags []string
+	for _, in := range inbounds {
+		inCfg := cfg.forInbound(in)
+		tag := "proxy"
+		if len(inbounds) > 1 {
+			tag = "proxy-" + in.Tag
+		}
+		if out := protocolFor(inCfg).xrayOutbound(inCfg, tag, c); out != nil {
+			proxies = append(proxies, out)
+			tags = append(tags, tag)
+		}
+	}
+	if len(proxies) == 0 {
+		return nil, errXrayUnsupported
+	}
+
+	rules := []any{
+		map[string]any{
+			"type":        "field",
+			"ip":          clientPrivateCIDRs,
+			"outboundTag": "direct",
+		},
+	}
+	rules = append(rules, xrayRuleSetRules(cfg.ClientBlockRuleSets, "block")...)
+	rules = append(rules, xrayRuleSetRules(cfg.ClientDirectRuleSets, "direct")...)
+
+	config := map[string]any{
+		"log": map[string]any{"loglevel": "warning"},
+		"inbounds": []any{
+			map[string]any{
+				"tag":      "socks-in",
+				"listen":   "127.0.0.1",
+				"port":     10808,
+				"protocol": "socks",
+				"settings": map[string]any{"udp": true},
+				"sniffing": map[string]any{
+					"enabled":      true,
+					"destOverride": []string{"http", "tls", "quic"},
+				},
+			},
+			map[string]any{
+				"tag":      "http-in",
+				"listen":   "127.0.0.1",
+				"port":     10809,
+				"protocol": "http",
+			},
+		},
+		"outbounds": append(proxies,
+			map[string]any{"tag": "direct", "protocol": "freedom"},
+			map[string]any{"tag": "block", "protocol": "blackhole"},
+		),
+	}
+
+	routing := map[string]any{
+		"domainStrategy": "IPIfNonMatch",
+	}
+	if len(tags) > 1 {
+		routing["balancers"] = []any{map[string]any{
+			"tag":      "proxy",
+			"selector": []string{"proxy-"},
+			"strategy": map[string]any{"type": "leastPing"},
+		}}
+		rules = append(rules, map[string]any{
+			"type":        "field",
+			"network":     "tcp,udp",
+			"balancerTag": "proxy",
+		})
+		config["observatory"] = map[string]any{
+			"subjectSelector": []string{"proxy-"},
+			"probeURL":        "https://www.gstatic.com/generate_204",
+			"probeInterval":   "3m",
+		}
+	}
+	routing["rules"] = rules
+	config["routing"] = routing
+	return config, nil
+}
+
+// xrayRuleSetRules maps sing-box rule-set names onto Xray's geodata
+// matchers, e.g. "geosite-ru" becomes "geosite:ru".
+func xrayRuleSetRules(names []string, outbound string) []any {
+	var domains, ips []string
+	for _, name := range names {
+		if code, ok := strings.CutPrefix(name, "geoip-"); ok {
+			ips = append(ips, "geoip:"+code)
+			continue
+		}
+		domains = append(domains, "geosite:"+strings.TrimPrefix(name, "geosite-"))
+	}
+
+	var rules []any
+	if len(domains) > 0 {
+		rules = append(rules, map[string]any{"type": "field", "domain": domains, "outboundTag": outbound})
+	}
+	if len(ips) > 0 {
+		rules = append(rules, map[string]any{"type": "field", "ip": ips, "outboundTag": outbound})
+	}
+	return rules
+}
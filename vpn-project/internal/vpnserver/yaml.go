@@ -0,0 +1,112 @@
+package vpnserver
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlMap is a mapping that keeps its key order when encoded.
+type yamlMap []yamlItem
+
+type yamlItem struct {
+	Key   string
+	Value any
+}
+
+// marshalYAML encodes v through a yaml.Node tree, so yamlMap keys keep their
+// order and plain Go maps use sortedYAMLMap's order.
+func marshalYAML(v yamlMap) ([]byte, error) {
+	node, err := yamlNode(v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func yamlNode(v any) (*yaml.Node, error) {
+	switch v := v.(type) {
+	case yamlMap:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for _, item := range v {
+			value, err := yamlNode(item.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", item.Key, err)
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: item.Key}, value)
+		}
+		return node, nil
+	case map[string]any:
+		return yamlNode(sortedYAMLMap(v))
+	case []string:
+		items := make([]any, len(v))
+		for i, s := range v {
+			items[i] = s
+		}
+		return yamlNode(items)
+	case []any:
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		if len(v) == 0 {
+			node.Style = yaml.FlowStyle
+		}
+		for _, item := range v {
+			child, err := yamlNode(item)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		return node, nil
+	case string, bool, int:
+		node := &yaml.Node{}
+		if err := node.Encode(v); err != nil {
+			return nil, err
+		}
+		return node, nil
+	default:
+		return nil, fmt.Errorf("unsupported yaml value %T", v)
+	}
+}
+
+// sortedYAMLMap orders keys alphabetically, except that "name" and "type"
+// lead so proxy entries read naturally.
+func sortedYAMLMap(m map[string]any) yamlMap {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	rank := func(k string) int {
+		switch k {
+		case "name":
+			return 0
+		case "type":
+			return 1
+		default:
+			return 2
+		}
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		if ra, rb := rank(a), rank(b); ra != rb {
+			return ra - rb
+		}
+		return strings.Compare(a, b)
+	})
+
+	out := make(yamlMap, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, yamlItem{k, m[k]})
+	}
+	return out
+}